@@ -0,0 +1,215 @@
+// Command wfspanreplay replays spans read from files into a Wavefront proxy, Wavefront direct ingestion
+// or the console, for load testing and reproducing incidents.
+//
+// Spans are read from JSON lines, in the encoding of tracer.RawSpan.MarshalJSON as written by the file
+// reporter, or from Wavefront span lines as captured from a proxy. Usage:
+//
+//	wfspanreplay [flags] [file ...]
+//
+// Files are read in order, and standard input is read when no file is given or the file is "-". The source of
+// span lines is not kept: all the spans are sent with the source set by -source, which defaults to the hostname.
+// For example, to replay a capture to a local proxy at 100 spans per second, as if it happened now:
+//
+//	wfspanreplay -proxy localhost -rewrite-timestamps -remap-trace-ids -rate 100 spans.txt
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/reporter"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+type config struct {
+	format            string
+	proxy             string
+	tracingPort       int
+	metricsPort       int
+	directURL         string
+	token             string
+	console           string
+	application       string
+	service           string
+	source            string
+	rewriteTimestamps bool
+	remapTraceIDs     bool
+	rate              float64
+	blockTimeout      time.Duration
+	timeout           time.Duration
+	files             []string
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "wfspanreplay:", err)
+		os.Exit(1)
+	}
+}
+
+func parseFlags(args []string, output io.Writer) (config, error) {
+	var cfg config
+	fs := flag.NewFlagSet("wfspanreplay", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&cfg.format, "format", "auto", "input format: auto, json or spanline")
+	fs.StringVar(&cfg.proxy, "proxy", "", "host of the Wavefront proxy to send the spans to")
+	fs.IntVar(&cfg.tracingPort, "tracing-port", 30000, "tracing port of the Wavefront proxy")
+	fs.IntVar(&cfg.metricsPort, "metrics-port", 2878, "metrics port of the Wavefront proxy, for the RED metrics and heartbeats")
+	fs.StringVar(&cfg.directURL, "direct", "", "Wavefront URL to send the spans to using direct ingestion, for example https://INSTANCE.wavefront.com")
+	fs.StringVar(&cfg.token, "token", os.Getenv("WAVEFRONT_TOKEN"), "API token for direct ingestion, defaults to $WAVEFRONT_TOKEN")
+	fs.StringVar(&cfg.console, "console", "", "print the spans to standard output instead: raw, summary or tree")
+	fs.StringVar(&cfg.application, "application", "replay", "application tag of the spans that do not have one")
+	fs.StringVar(&cfg.service, "service", "replay", "service tag of the spans that do not have one")
+	fs.StringVar(&cfg.source, "source", "", "source of all the spans, replacing the source of span lines, defaults to the hostname")
+	fs.BoolVar(&cfg.rewriteTimestamps, "rewrite-timestamps", false, "shift the timestamps so that the first span starts now")
+	fs.BoolVar(&cfg.remapTraceIDs, "remap-trace-ids", false, "replace each trace id with a new random one")
+	fs.Float64Var(&cfg.rate, "rate", 0, "maximum number of spans replayed per second, 0 for no limit")
+	fs.DurationVar(&cfg.blockTimeout, "block-timeout", time.Second, "how long to wait for room in the span buffer before dropping a span")
+	fs.DurationVar(&cfg.timeout, "timeout", 30*time.Second, "how long to wait for the queued spans to be sent at the end")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	cfg.files = fs.Args()
+
+	outputs := 0
+	for _, set := range []bool{cfg.proxy != "", cfg.directURL != "", cfg.console != ""} {
+		if set {
+			outputs++
+		}
+	}
+	if outputs != 1 {
+		return cfg, errors.New("exactly one of -proxy, -direct and -console is required")
+	}
+	if cfg.directURL != "" && cfg.token == "" {
+		return cfg, errors.New("-direct requires an API token")
+	}
+	switch cfg.format {
+	case "auto", "json", "spanline":
+	default:
+		return cfg, fmt.Errorf("unknown format %q", cfg.format)
+	}
+	if cfg.rate < 0 {
+		return cfg, errors.New("-rate must not be negative")
+	}
+	return cfg, nil
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	cfg, err := parseFlags(args, stderr)
+	if err != nil {
+		return err
+	}
+
+	rep, closeSender, err := newReporter(cfg, stdout)
+	if err != nil {
+		return err
+	}
+
+	r := newReplayer(cfg)
+	files := cfg.files
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var replayErr error
+	for _, name := range files {
+		if replayErr = replayFile(r, rep, name, cfg.format, stdin); replayErr != nil {
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	if err := shutdown(ctx, rep); err != nil && replayErr == nil {
+		replayErr = err
+	}
+	if closeSender != nil {
+		closeSender()
+	}
+	fmt.Fprintf(stderr, "replayed %d spans\n", r.count)
+	return replayErr
+}
+
+func newReporter(cfg config, stdout io.Writer) (tracer.SpanReporter, func(), error) {
+	if cfg.console != "" {
+		var format reporter.ConsoleFormat
+		switch cfg.console {
+		case "raw":
+			format = reporter.ConsoleRaw
+		case "summary":
+			format = reporter.ConsoleSummary
+		case "tree":
+			format = reporter.ConsoleTree
+		default:
+			return nil, nil, fmt.Errorf("unknown console format %q", cfg.console)
+		}
+		return reporter.NewConsoleSpanReporter(cfg.source, reporter.ConsoleWriter(stdout), reporter.ConsoleOutputFormat(format)), nil, nil
+	}
+
+	var sender senders.Sender
+	var err error
+	if cfg.proxy != "" {
+		sender, err = senders.NewProxySender(&senders.ProxyConfiguration{
+			Host:        cfg.proxy,
+			TracingPort: cfg.tracingPort,
+			MetricsPort: cfg.metricsPort,
+		})
+	} else {
+		sender, err = senders.NewDirectSender(&senders.DirectConfiguration{Server: cfg.directURL, Token: cfg.token})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := []reporter.Option{
+		// wait for room in the buffer rather than dropping spans
+		reporter.Backpressure(reporter.BlockWithTimeout),
+		reporter.BlockTimeout(cfg.blockTimeout),
+	}
+	if cfg.source != "" {
+		options = append(options, reporter.Source(cfg.source))
+	}
+	rep := reporter.New(sender, application.New(cfg.application, cfg.service), options...)
+	return rep, sender.Close, nil
+}
+
+// shutdown sends the queued spans, if the reporter supports it, and closes the reporter.
+func shutdown(ctx context.Context, rep tracer.SpanReporter) error {
+	if s, ok := rep.(interface {
+		Shutdown(ctx context.Context) (int, error)
+	}); ok {
+		lost, err := s.Shutdown(ctx)
+		if lost > 0 {
+			return fmt.Errorf("%d spans could not be sent", lost)
+		}
+		return err
+	}
+	return rep.Close()
+}
+
+func replayFile(r *replayer, rep tracer.SpanReporter, name, format string, stdin io.Reader) error {
+	var input io.Reader = stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	spans, err := newSpanReader(input, format)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if err := r.replay(spans, rep); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
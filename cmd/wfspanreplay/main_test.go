@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+)
+
+const (
+	traceID = "7b3bf470-9456-11e8-9eb6-529269fb1459"
+	spanID  = "0313bafe-9457-11e8-9eb6-529269fb1459"
+	childID = "2f64e538-9457-11e8-9eb6-529269fb1459"
+)
+
+func TestRun_SpanLines(t *testing.T) {
+	input := "# captured from the proxy\n" +
+		`getUser source=host traceId=` + traceID + ` spanId=` + childID + ` parent=` + spanID + ` http.method=GET 1533531013343 12` + "\n" +
+		`handle source=host traceId=` + traceID + ` spanId=` + spanID + ` 1533531013340 20` + "\n"
+
+	var stdout, stderr bytes.Buffer
+	require.NoError(t, run([]string{"-console", "tree"}, strings.NewReader(input), &stdout, &stderr))
+	assert.Equal(t, "trace "+traceID+" (2 spans)\n  handle 20ms\n    getUser 12ms http.method=GET\n", stdout.String())
+	assert.Equal(t, "replayed 2 spans\n", stderr.String())
+}
+
+func TestRun_JSONRewritten(t *testing.T) {
+	parent := tracer.SpanContext{TraceID: traceID, SpanID: spanID}
+	span := tracer.RawSpan{
+		Context:    tracer.SpanContext{TraceID: traceID, SpanID: childID},
+		References: []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: parent}},
+		Operation:  "getUser",
+		Start:      time.Unix(1533531013, 0),
+		Duration:   time.Millisecond,
+	}
+	data, err := json.Marshal(span)
+	require.NoError(t, err)
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"-console", "summary", "-rewrite-timestamps", "-remap-trace-ids"}
+	require.NoError(t, run(args, bytes.NewReader(append(data, '\n')), &stdout, &stderr))
+	out := stdout.String()
+	assert.Contains(t, out, " getUser 1ms traceId=")
+	assert.NotContains(t, out, traceID)
+	assert.Contains(t, out, "spanId="+childID+" parent="+spanID)
+	assert.True(t, strings.HasPrefix(out, time.Now().Format("2006-01-02T")), out)
+}
+
+func TestDetectFormat_LongComment(t *testing.T) {
+	input := "# " + strings.Repeat("x", 10000) + "\n" + `{"operationName":"op"}` + "\n"
+	spans, err := newSpanReader(strings.NewReader(input), "auto")
+	require.NoError(t, err)
+	assert.IsType(t, &jsonReader{}, spans, "the format is detected after a comment longer than the default buffer")
+
+	_, err = newSpanReader(strings.NewReader("# "+strings.Repeat("x", maxDetectSize)), "auto")
+	assert.EqualError(t, err, "could not detect the input format")
+}
+
+func TestRun_Errors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.EqualError(t, run(nil, strings.NewReader(""), &stdout, &stderr), "exactly one of -proxy, -direct and -console is required")
+	assert.EqualError(t, run([]string{"-console", "raw", "-format", "xml"}, strings.NewReader(""), &stdout, &stderr), `unknown format "xml"`)
+
+	err := run([]string{"-console", "raw"}, strings.NewReader("op source=h 1 2\n"), &stdout, &stderr)
+	assert.EqualError(t, err, "-: line 1, offset 15: missing traceId")
+
+	err = run([]string{"-console", "raw"}, strings.NewReader(`{"operation":"op","durationNanos":"x"}`+"\n"), &stdout, &stderr)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "-: line 1: json: "), err.Error())
+}
+
+func TestParseFlags(t *testing.T) {
+	var stderr bytes.Buffer
+	cfg, err := parseFlags([]string{"-proxy", "localhost"}, &stderr)
+	require.NoError(t, err)
+	assert.Equal(t, 30000, cfg.tracingPort)
+	assert.Equal(t, 2878, cfg.metricsPort)
+	assert.Equal(t, time.Second, cfg.blockTimeout)
+	assert.Equal(t, 30*time.Second, cfg.timeout)
+
+	cfg, err = parseFlags([]string{"-proxy", "localhost", "-metrics-port", "2879", "-block-timeout", "5s"}, &stderr)
+	require.NoError(t, err)
+	assert.Equal(t, 2879, cfg.metricsPort)
+	assert.Equal(t, 5*time.Second, cfg.blockTimeout)
+}
+
+func TestReplayer_RemapTraceIDs(t *testing.T) {
+	r := newReplayer(config{remapTraceIDs: true})
+	parent := tracer.SpanContext{TraceID: traceID, SpanID: spanID}
+	child := r.rewrite(tracer.RawSpan{
+		Context:    tracer.SpanContext{TraceID: traceID, SpanID: childID},
+		References: []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: parent}},
+	})
+	root := r.rewrite(tracer.RawSpan{Context: parent})
+
+	assert.NotEqual(t, traceID, root.Context.TraceID)
+	assert.Equal(t, root.Context.TraceID, child.Context.TraceID)
+	assert.Equal(t, root.Context.TraceID, child.References[0].ReferencedContext.(tracer.SpanContext).TraceID)
+	assert.Equal(t, spanID, child.References[0].ReferencedContext.(tracer.SpanContext).SpanID)
+}
+
+func TestReplayer_Rate(t *testing.T) {
+	r := newReplayer(config{rate: 10})
+	now := time.Unix(0, 0)
+	var slept []time.Duration
+	r.now = func() time.Time { return now }
+	r.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	r.wait()
+	r.wait()
+	now = now.Add(50 * time.Millisecond)
+	r.wait()
+	now = now.Add(time.Second)
+	r.wait()
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 150 * time.Millisecond}, slept)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/reporter"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+)
+
+// spanReader reads spans until io.EOF.
+type spanReader interface {
+	Read() (tracer.RawSpan, error)
+}
+
+// maxDetectSize is the maximum number of bytes peeked at to detect the input format, skipping empty lines
+// and comments.
+const maxDetectSize = 1024 * 1024
+
+// newSpanReader returns a reader for the format, detecting it from the first line when the format is "auto".
+func newSpanReader(input io.Reader, format string) (spanReader, error) {
+	br := bufio.NewReaderSize(input, maxDetectSize)
+	if format == "auto" {
+		var err error
+		if format, err = detectFormat(br); err != nil {
+			return nil, err
+		}
+	}
+	if format == "json" {
+		scanner := bufio.NewScanner(br)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		return &jsonReader{scanner: scanner}, nil
+	}
+	return spanLineReader{reporter.NewSpanLineReader(br)}, nil
+}
+
+// detectFormat peeks at the first line which is not empty or a comment, reading more of the input while it
+// only holds empty lines and comments, up to the size of the reader. Span lines never start with '{'.
+func detectFormat(br *bufio.Reader) (string, error) {
+	for size := 4096; ; size *= 2 {
+		if size > br.Size() {
+			size = br.Size()
+		}
+		data, err := br.Peek(size)
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if strings.HasPrefix(line, "{") {
+				return "json", nil
+			}
+			return "spanline", nil
+		}
+		if err == io.EOF {
+			return "spanline", nil
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return "", err
+		}
+		if size >= br.Size() {
+			return "", fmt.Errorf("could not detect the input format")
+		}
+	}
+}
+
+type jsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonReader) Read() (tracer.RawSpan, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var span tracer.RawSpan
+		if err := json.Unmarshal([]byte(line), &span); err != nil {
+			return tracer.RawSpan{}, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		return span, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return tracer.RawSpan{}, err
+	}
+	return tracer.RawSpan{}, io.EOF
+}
+
+type spanLineReader struct {
+	*reporter.SpanLineReader
+}
+
+func (r spanLineReader) Read() (tracer.RawSpan, error) {
+	span, _, err := r.SpanLineReader.Read()
+	return span, err
+}
+
+// replayer rewrites spans and reports them at a limited rate.
+type replayer struct {
+	rewriteTimestamps bool
+	offset            time.Duration // added to the timestamps, set from the first span
+	remapTraceIDs     bool
+	traceIDs          map[string]string
+	interval          time.Duration // between two spans, 0 for no limit
+	next              time.Time
+	now               func() time.Time
+	sleep             func(time.Duration)
+	count             int
+}
+
+func newReplayer(cfg config) *replayer {
+	r := &replayer{
+		rewriteTimestamps: cfg.rewriteTimestamps,
+		remapTraceIDs:     cfg.remapTraceIDs,
+		traceIDs:          make(map[string]string),
+		now:               time.Now,
+		sleep:             time.Sleep,
+	}
+	if cfg.rate > 0 {
+		r.interval = time.Duration(float64(time.Second) / cfg.rate)
+	}
+	return r
+}
+
+func (r *replayer) replay(spans spanReader, rep tracer.SpanReporter) error {
+	for {
+		span, err := spans.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r.wait()
+		rep.ReportSpan(r.rewrite(span))
+		r.count++
+	}
+}
+
+// wait blocks until the next span may be reported according to the rate limit.
+func (r *replayer) wait() {
+	if r.interval == 0 {
+		return
+	}
+	now := r.now()
+	if r.next.Before(now) {
+		r.next = now
+	} else {
+		r.sleep(r.next.Sub(now))
+	}
+	r.next = r.next.Add(r.interval)
+}
+
+func (r *replayer) rewrite(span tracer.RawSpan) tracer.RawSpan {
+	if r.rewriteTimestamps {
+		if r.count == 0 {
+			r.offset = r.now().Sub(span.Start)
+		}
+		span.Start = span.Start.Add(r.offset)
+		if len(span.Logs) > 0 {
+			logs := make([]opentracing.LogRecord, len(span.Logs))
+			for i, lr := range span.Logs {
+				lr.Timestamp = lr.Timestamp.Add(r.offset)
+				logs[i] = lr
+			}
+			span.Logs = logs
+		}
+	}
+
+	if r.remapTraceIDs {
+		span.Context.TraceID = r.traceID(span.Context.TraceID)
+		if len(span.References) > 0 {
+			refs := make([]opentracing.SpanReference, len(span.References))
+			for i, ref := range span.References {
+				if refCtx, ok := ref.ReferencedContext.(tracer.SpanContext); ok {
+					refCtx.TraceID = r.traceID(refCtx.TraceID)
+					ref.ReferencedContext = refCtx
+				}
+				refs[i] = ref
+			}
+			span.References = refs
+		}
+	}
+	return span
+}
+
+// traceID returns the new trace id replacing the given one.
+func (r *replayer) traceID(id string) string {
+	remapped, found := r.traceIDs[id]
+	if !found {
+		remapped = uuid.New().String()
+		r.traceIDs[id] = remapped
+	}
+	return remapped
+}
@@ -0,0 +1,97 @@
+// Package lineformat tokenizes lines in the Wavefront data formats.
+package lineformat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyntaxError describes a malformed line.
+type SyntaxError struct {
+	// Offset is the position in the line of the byte where the error was detected.
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Msg)
+}
+
+// Token is a bare or quoted word of a line, or a key=value pair.
+type Token struct {
+	Offset int
+	Key    string
+	HasKey bool
+	Value  string
+}
+
+// Tokenize splits a line into words and key=value pairs, unquoting them.
+// Quoted strings may contain escaped quotes and line breaks, as written by the Wavefront senders.
+func Tokenize(line string) ([]Token, error) {
+	var tokens []Token
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return tokens, nil
+		}
+
+		tok := Token{Offset: i}
+		word, next, err := scanWord(line, i, true)
+		if err != nil {
+			return nil, err
+		}
+		i = next
+		if i < len(line) && line[i] == '=' {
+			tok.Key, tok.HasKey = word, true
+			word, next, err = scanWord(line, i+1, false)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+		}
+		tok.Value = word
+		if i < len(line) && !isSpace(line[i]) {
+			return nil, &SyntaxError{Offset: i, Msg: fmt.Sprintf("unexpected character %q", line[i])}
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+// scanWord returns the quoted or bare word starting at i and the position following it.
+// Bare keys end at '=', bare values only at whitespace.
+func scanWord(line string, i int, isKey bool) (string, int, error) {
+	if i < len(line) && line[i] == '"' {
+		var sb strings.Builder
+		for j := i + 1; j < len(line); j++ {
+			switch c := line[j]; {
+			case c == '"':
+				return sb.String(), j + 1, nil
+			case c == '\\' && j+1 < len(line) && line[j+1] == '"':
+				sb.WriteByte('"')
+				j++
+			case c == '\\' && j+1 < len(line) && line[j+1] == 'n':
+				sb.WriteByte('\n')
+				j++
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", 0, &SyntaxError{Offset: i, Msg: "unterminated quoted string"}
+	}
+
+	j := i
+	for j < len(line) && !isSpace(line[j]) && !(isKey && line[j] == '=') {
+		if line[j] == '"' {
+			return "", 0, &SyntaxError{Offset: j, Msg: "unexpected quote"}
+		}
+		j++
+	}
+	return line[i:j], j, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,188 @@
+package reporter
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+)
+
+// BackpressurePolicy controls what the reporter does with a span when its in-memory buffer is full.
+type BackpressurePolicy int
+
+const (
+	// DropNewest drops the incoming span when the buffer is full. This is the default.
+	DropNewest BackpressurePolicy = iota
+
+	// DropOldest evicts the oldest buffered span to make room for the incoming one.
+	DropOldest
+
+	// BlockWithTimeout blocks the caller until there is room in the buffer or the timeout set
+	// with BlockTimeout expires, in which case the incoming span is dropped.
+	BlockWithTimeout
+
+	// PriorityEviction evicts buffered ordinary spans to make room for error or debug spans.
+	// Error and debug spans are only dropped when the buffer holds nothing else.
+	// Spans are enqueued under a lock with this policy, and buffered spans may be reordered on eviction.
+	PriorityEviction
+)
+
+const defaultBlockTimeout = 100 * time.Millisecond
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case BlockWithTimeout:
+		return "block"
+	case PriorityEviction:
+		return "priority"
+	}
+	return "unknown"
+}
+
+// enqueue adds the span to the in-memory buffer according to the configured backpressure policy.
+func (t *reporter) enqueue(span tracer.RawSpan) {
+	switch t.backpressure {
+	case DropOldest:
+		t.enqueueDropOldest(span)
+	case BlockWithTimeout:
+		t.enqueueBlocking(span)
+	case PriorityEviction:
+		t.enqueuePriority(span)
+	default:
+		select {
+		case t.spansCh <- span:
+		default:
+			t.drop(t.spansDropped, "buffer full, dropping span", span)
+		}
+	}
+}
+
+func (t *reporter) enqueueDropOldest(span tracer.RawSpan) {
+	select {
+	case t.spansCh <- span:
+		return
+	default:
+	}
+
+	select {
+	case old := <-t.spansCh:
+		t.drop(t.spansDroppedOldest, "buffer full, evicting oldest span", old)
+	default:
+	}
+
+	// another producer may have taken the freed slot, in which case the incoming span is dropped.
+	select {
+	case t.spansCh <- span:
+	default:
+		t.drop(t.spansDropped, "buffer full, dropping span", span)
+	}
+}
+
+func (t *reporter) enqueueBlocking(span tracer.RawSpan) {
+	select {
+	case t.spansCh <- span:
+		return
+	default:
+	}
+
+	timer := time.NewTimer(t.blockTimeout)
+	defer timer.Stop()
+	select {
+	case t.spansCh <- span:
+	case <-timer.C:
+		t.drop(t.spansDroppedTimeout, "timed out waiting for buffer, dropping span", span)
+	}
+}
+
+func (t *reporter) enqueuePriority(span tracer.RawSpan) {
+	// producers are serialized so that priority spans taken out of the buffer below can always be put back.
+	t.queueMtx.Lock()
+	defer t.queueMtx.Unlock()
+
+	select {
+	case t.spansCh <- span:
+		return
+	default:
+	}
+
+	if !isPrioritySpan(span) {
+		t.drop(t.spansDropped, "buffer full, dropping span", span)
+		return
+	}
+
+	var kept []tracer.RawSpan
+evict:
+	for i := 0; i < cap(t.spansCh); i++ {
+		select {
+		case old := <-t.spansCh:
+			if isPrioritySpan(old) {
+				kept = append(kept, old)
+				continue
+			}
+			t.drop(t.spansEvicted, "buffer full, evicting span", old)
+			break evict
+		default:
+			// the buffer was drained concurrently, so there is room now.
+			break evict
+		}
+	}
+
+	// the kept spans fit back unless the buffer was filled concurrently, so do not block on it
+	for _, s := range kept {
+		select {
+		case t.spansCh <- s:
+		default:
+			t.drop(t.spansDropped, "buffer full of priority spans, dropping span", s)
+		}
+	}
+	select {
+	case t.spansCh <- span:
+	default:
+		t.drop(t.spansDropped, "buffer full of priority spans, dropping span", span)
+	}
+}
+
+// droppedSpan is a span that did not fit in the buffer, waiting to be spilled.
+type droppedSpan struct {
+	span    tracer.RawSpan
+	counter metrics.Counter
+	msg     string
+}
+
+// drop counts a span that did not fit in the buffer, unless it can be spilled to disk instead. Spans are spilled
+// from another goroutine, so that ReportSpan does not wait for the disk, and counted as dropped when too many
+// are waiting to be spilled.
+func (t *reporter) drop(counter metrics.Counter, msg string, span tracer.RawSpan) {
+	if t.spillCh != nil {
+		select {
+		case t.spillCh <- droppedSpan{span: span, counter: counter, msg: msg}:
+			return
+		default:
+		}
+	}
+	t.countDropped(counter, msg, span)
+}
+
+func (t *reporter) countDropped(counter metrics.Counter, msg string, span tracer.RawSpan) {
+	counter.Inc(1)
+	if t.loggingAllowed() {
+		log.Printf("%s: %s\n", msg, span.Operation)
+	}
+}
+
+// isPrioritySpan reports whether the span is tagged as an error or debug span.
+func isPrioritySpan(span tracer.RawSpan) bool {
+	return hasTrueTag(string(ext.Error), span.Tags) || hasTrueTag("debug", span.Tags)
+}
+
+func hasTrueTag(key string, tags map[string]interface{}) bool {
+	value, found := getAppTag(key, "", tags, DefaultValueEncoder)
+	return found && strings.EqualFold(value, "true")
+}
@@ -0,0 +1,116 @@
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+)
+
+func newBufferedReporter(policy BackpressurePolicy, size int) *reporter {
+	return &reporter{
+		backpressure:        policy,
+		blockTimeout:        10 * time.Millisecond,
+		bufferSize:          size,
+		spansCh:             make(chan tracer.RawSpan, size),
+		spansDropped:        metrics.NewCounter(),
+		spansDroppedOldest:  metrics.NewCounter(),
+		spansDroppedTimeout: metrics.NewCounter(),
+		spansEvicted:        metrics.NewCounter(),
+	}
+}
+
+func bufferedOperations(r *reporter) []string {
+	var ops []string
+	for len(r.spansCh) > 0 {
+		ops = append(ops, (<-r.spansCh).Operation)
+	}
+	return ops
+}
+
+func TestBackpressure_DropNewest(t *testing.T) {
+	r := newBufferedReporter(DropNewest, 2)
+	for _, op := range []string{"a", "b", "c"} {
+		r.enqueue(tracer.RawSpan{Operation: op})
+	}
+	assert.Equal(t, int64(1), r.spansDropped.Count())
+	assert.Equal(t, []string{"a", "b"}, bufferedOperations(r))
+}
+
+func TestBackpressure_DropOldest(t *testing.T) {
+	r := newBufferedReporter(DropOldest, 2)
+	for _, op := range []string{"a", "b", "c"} {
+		r.enqueue(tracer.RawSpan{Operation: op})
+	}
+	assert.Equal(t, int64(0), r.spansDropped.Count())
+	assert.Equal(t, int64(1), r.spansDroppedOldest.Count())
+	assert.Equal(t, []string{"b", "c"}, bufferedOperations(r))
+}
+
+func TestBackpressure_BlockWithTimeout(t *testing.T) {
+	r := newBufferedReporter(BlockWithTimeout, 1)
+	r.enqueue(tracer.RawSpan{Operation: "a"})
+	r.enqueue(tracer.RawSpan{Operation: "b"})
+	assert.Equal(t, int64(1), r.spansDroppedTimeout.Count())
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		<-r.spansCh
+	}()
+	r.blockTimeout = time.Second
+	r.enqueue(tracer.RawSpan{Operation: "c"})
+	assert.Equal(t, int64(1), r.spansDroppedTimeout.Count())
+	assert.Equal(t, []string{"c"}, bufferedOperations(r))
+}
+
+func TestBackpressure_PriorityEviction(t *testing.T) {
+	r := newBufferedReporter(PriorityEviction, 3)
+	r.enqueue(tracer.RawSpan{Operation: "error", Tags: map[string]interface{}{"error": true}})
+	r.enqueue(tracer.RawSpan{Operation: "ordinary"})
+	r.enqueue(tracer.RawSpan{Operation: "debug", Tags: map[string]interface{}{"debug": "true"}})
+
+	// ordinary spans never evict anything
+	r.enqueue(tracer.RawSpan{Operation: "dropped"})
+	assert.Equal(t, int64(1), r.spansDropped.Count())
+
+	r.enqueue(tracer.RawSpan{Operation: "error2", Tags: map[string]interface{}{"error": "true"}})
+	assert.Equal(t, int64(1), r.spansEvicted.Count())
+
+	// only priority spans left in the buffer
+	r.enqueue(tracer.RawSpan{Operation: "error3", Tags: map[string]interface{}{"error": true}})
+	assert.Equal(t, int64(2), r.spansDropped.Count())
+	assert.Equal(t, int64(1), r.spansEvicted.Count())
+
+	assert.ElementsMatch(t, []string{"error", "debug", "error2"}, bufferedOperations(r))
+}
+
+func TestBackpressure_SpillsAsync(t *testing.T) {
+	q, err := openSpillQueue(tempSpillDir(t), 1<<20, 1<<10)
+	require.NoError(t, err)
+	r := newBufferedReporter(DropNewest, 1)
+	r.spillQueue = q
+	r.spansSpilled = metrics.NewCounter()
+	r.spillDropped = metrics.NewCounter()
+
+	// without room to hand the span to the spilling goroutine, it is dropped
+	r.spillCh = make(chan droppedSpan)
+	r.enqueue(newSpan("a"))
+	r.enqueue(newSpan("b"))
+	assert.Equal(t, int64(1), r.spansDropped.Count())
+	assert.Equal(t, int64(0), r.spansSpilled.Count())
+
+	r.spillCh = make(chan droppedSpan, 1)
+	r.spillDone = make(chan struct{})
+	r.enqueue(newSpan("c"))
+	assert.Len(t, r.spillCh, 1, "the span is spilled by another goroutine")
+	go r.spillDroppedSpans()
+	close(r.spillCh)
+	<-r.spillDone
+	assert.Equal(t, int64(1), r.spansDropped.Count())
+	assert.Equal(t, int64(1), r.spansSpilled.Count())
+	assert.Equal(t, []string{"c"}, drainSpillQueue(q))
+	assert.Equal(t, []string{"a"}, bufferedOperations(r))
+}
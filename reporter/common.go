@@ -1,8 +1,9 @@
 package reporter
 
 import (
-	"fmt"
+	"encoding/hex"
 
+	"github.com/google/uuid"
 	"github.com/opentracing/opentracing-go"
 	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
 	wf "github.com/wavefronthq/wavefront-sdk-go/senders"
@@ -24,20 +25,33 @@ func prepareReferences(span tracer.RawSpan) ([]string, []string) {
 	return parents, followsFrom
 }
 
-func prepareTags(span tracer.RawSpan) []wf.SpanTag {
+// parentIndex returns the index of the first ChildOf reference of the span, or -1 if it has none, for the formats
+// with a single parent.
+func parentIndex(span tracer.RawSpan) int {
+	for i, ref := range span.References {
+		if ref.Type == opentracing.ChildOfRef {
+			return i
+		}
+	}
+	return -1
+}
+
+func prepareTags(span tracer.RawSpan, encode ValueEncoder) []wf.SpanTag {
 	if len(span.Tags) == 0 {
 		return nil
 	}
-	tags := make([]wf.SpanTag, len(span.Tags))
-	i := 0
+	tags := make([]wf.SpanTag, 0, len(span.Tags))
 	for k, v := range span.Tags {
-		tags[i] = wf.SpanTag{Key: k, Value: fmt.Sprintf("%v", v)}
-		i++
+		tags = append(tags, wf.SpanTag{Key: k, Value: encode(v)})
+		// the tags with several values are repeated
+		for _, additional := range span.AdditionalTags[k] {
+			tags = append(tags, wf.SpanTag{Key: k, Value: encode(additional)})
+		}
 	}
 	return tags
 }
 
-func prepareLogs(span tracer.RawSpan) []wf.SpanLog {
+func prepareLogs(span tracer.RawSpan, encode ValueEncoder) []wf.SpanLog {
 	if len(span.Logs) == 0 {
 		return nil
 	}
@@ -45,17 +59,18 @@ func prepareLogs(span tracer.RawSpan) []wf.SpanLog {
 	for i, log := range span.Logs {
 		fields := make(map[string]string)
 		for _, field := range log.Fields {
-			fields[field.Key()] = fmt.Sprint(field.Value())
+			fields[field.Key()] = encode(field.Value())
 		}
 		logs[i] = wf.SpanLog{Timestamp: log.Timestamp.UnixNano() / 1000, Fields: fields}
 	}
 	return logs
 }
 
-func getAppTag(key, defaultVal string, tags map[string]interface{}) (string, bool) {
+// getAppTag returns the value of the tag encoded with the encoder, or the default value if the tag is not set.
+func getAppTag(key, defaultVal string, tags map[string]interface{}, encode ValueEncoder) (string, bool) {
 	if len(tags) > 0 {
 		if v, found := tags[key]; found {
-			return fmt.Sprint(v), true
+			return encode(v), true
 		}
 	}
 	return defaultVal, false
@@ -66,3 +81,21 @@ func replaceTag(tags map[string]string, key, value string, replace bool) {
 		tags[key] = value
 	}
 }
+
+// idBytes returns the last size bytes of the UUID id.
+func idBytes(id string, size int) ([]byte, error) {
+	u, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	return u[len(u)-size:], nil
+}
+
+// hexID returns the last size bytes of the UUID id as lowercase hex.
+func hexID(id string, size int) (string, error) {
+	b, err := idBytes(id, size)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,38 +1,416 @@
 package reporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/rcrowley/go-metrics"
 	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
 )
 
-// CompositeSpanReporter reports spans to multiple SpanReporter's.
+// MultiError holds the errors of several reporters. errors.Is and errors.As match any of the errors.
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors.
+func (m MultiError) Unwrap() []error {
+	return m
+}
+
+// Is reports whether any of the errors matches target.
+func (m MultiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error that matches target, and if so, sets target to that error value and returns true.
+func (m MultiError) As(target interface{}) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinErrors returns a MultiError of the non nil errors, or nil if there are none.
+func joinErrors(errs []error) error {
+	var m MultiError
+	for _, err := range errs {
+		if err != nil {
+			m = append(m, err)
+		}
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+type compositeConfig struct {
+	bufferSize int
+	names      []string
+	registry   metrics.Registry
+}
+
+// CompositeOption allows customizing the CompositeSpanReporter.
+type CompositeOption func(*compositeConfig)
+
+// CompositeAsync reports spans to each reporter from its own goroutine through a queue of the given size,
+// so that a slow reporter does not delay the others. Spans are dropped for a reporter whose queue is full.
+// By default, spans are reported to the reporters one after the other from the calling goroutine.
+func CompositeAsync(bufferSize int) CompositeOption {
+	return func(cfg *compositeConfig) {
+		cfg.bufferSize = bufferSize
+	}
+}
+
+// CompositeNames sets the names of the reporters, in the order they are given, used in the metrics and errors.
+// Defaults to the position of the reporter, starting at 0.
+func CompositeNames(names ...string) CompositeOption {
+	return func(cfg *compositeConfig) {
+		cfg.names = names
+	}
+}
+
+// CompositeMetricsRegistry sets the registry of the per reporter metrics, named "<name>.spans.received",
+// "<name>.spans.dropped", "<name>.panics", "<name>.errors" and "<name>.queue.size". Defaults to a new registry.
+func CompositeMetricsRegistry(registry metrics.Registry) CompositeOption {
+	return func(cfg *compositeConfig) {
+		cfg.registry = registry
+	}
+}
+
+// flusher is implemented by the reporters supporting Flush, such as the WavefrontSpanReporter.
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// shutdowner is implemented by the reporters supporting Shutdown, such as the WavefrontSpanReporter.
+type shutdowner interface {
+	Shutdown(ctx context.Context) (int, error)
+}
+
+// CompositeSpanReporter reports spans to multiple SpanReporter's. A panic in a reporter is recovered and
+// does not prevent the others from receiving the span.
 type CompositeSpanReporter struct {
-	reporters []tracer.SpanReporter
+	*composite
+}
+
+// composite holds the state shared by the copies of a CompositeSpanReporter.
+type composite struct {
+	children []*compositeChild
+	intake   sync.RWMutex // guards closing the queues
+	closed   bool
+}
+
+type compositeChild struct {
+	name     string
+	reporter tracer.SpanReporter
+
+	// only set with CompositeAsync
+	spansCh   chan tracer.RawSpan
+	flushReqs chan chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	lost      int64 // spans left in the queue when stopped, updated atomically
+
+	spansReceived metrics.Counter
+	spansDropped  metrics.Counter
+	panics        metrics.Counter
+	errorsCount   metrics.Counter
 }
 
 // NewCompositeSpanReporter returns a SpanReporter with multiple sub reporters.
 func NewCompositeSpanReporter(reporters ...tracer.SpanReporter) tracer.SpanReporter {
-	return CompositeSpanReporter{reporters: reporters}
+	return NewCompositeSpanReporterWithOptions(reporters)
+}
+
+// NewCompositeSpanReporterWithOptions returns a SpanReporter with multiple sub reporters, customized with options.
+func NewCompositeSpanReporterWithOptions(reporters []tracer.SpanReporter, options ...CompositeOption) tracer.SpanReporter {
+	cfg := compositeConfig{}
+	for _, option := range options {
+		option(&cfg)
+	}
+	if cfg.registry == nil {
+		cfg.registry = metrics.NewRegistry()
+	}
+
+	c := CompositeSpanReporter{&composite{}}
+	for i, reporter := range reporters {
+		name := strconv.Itoa(i)
+		if i < len(cfg.names) {
+			name = cfg.names[i]
+		}
+		child := &compositeChild{
+			name:          name,
+			reporter:      reporter,
+			spansReceived: metrics.GetOrRegisterCounter(name+".spans.received", cfg.registry),
+			spansDropped:  metrics.GetOrRegisterCounter(name+".spans.dropped", cfg.registry),
+			panics:        metrics.GetOrRegisterCounter(name+".panics", cfg.registry),
+			errorsCount:   metrics.GetOrRegisterCounter(name+".errors", cfg.registry),
+		}
+		if cfg.bufferSize > 0 {
+			child.spansCh = make(chan tracer.RawSpan, cfg.bufferSize)
+			child.flushReqs = make(chan chan struct{})
+			child.stop = make(chan struct{})
+			child.done = make(chan struct{})
+			cfg.registry.GetOrRegister(name+".queue.size", metrics.NewFunctionalGauge(func() int64 {
+				return int64(len(child.spansCh))
+			}))
+			go child.process()
+		}
+		c.children = append(c.children, child)
+	}
+	return c
 }
 
 // ReportSpan complies with the `tracer.SpanReporter` interface.
 func (c CompositeSpanReporter) ReportSpan(span tracer.RawSpan) {
-	for _, reporter := range c.reporters {
-		reporter.ReportSpan(span)
+	c.intake.RLock()
+	defer c.intake.RUnlock()
+	for _, child := range c.children {
+		c.dispatch(child, span)
 	}
 }
 
-func (c CompositeSpanReporter) Close() error {
-	errStr := ""
-	for _, reporter := range c.reporters {
-		err := reporter.Close()
+// reportTo reports the span to a single reporter, as ReportSpan does.
+func (c CompositeSpanReporter) reportTo(child *compositeChild, span tracer.RawSpan) {
+	c.intake.RLock()
+	defer c.intake.RUnlock()
+	c.dispatch(child, span)
+}
+
+// dispatch reports the span to the reporter, or queues it with CompositeAsync. The intake lock must be held.
+func (c CompositeSpanReporter) dispatch(child *compositeChild, span tracer.RawSpan) {
+	if c.closed {
+		child.spansDropped.Inc(1)
+		return
+	}
+	child.spansReceived.Inc(1)
+	if child.spansCh == nil {
+		child.report(span)
+		return
+	}
+	select {
+	case child.spansCh <- span:
+	default:
+		child.spansDropped.Inc(1)
+		log.Printf("composite reporter %s buffer full, dropping span: %s\n", child.name, span.Operation)
+	}
+}
+
+// report reports the span to the reporter, recovering from panics.
+func (c *compositeChild) report(span tracer.RawSpan) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.panics.Inc(1)
+			log.Printf("composite reporter %s panic reporting span %s: %v\n%s", c.name, span.Operation, p, debug.Stack())
+		}
+	}()
+	c.reporter.ReportSpan(span)
+}
+
+func (c *compositeChild) process() {
+	defer close(c.done)
+	for {
+		select {
+		case span, more := <-c.spansCh:
+			if !more {
+				return
+			}
+			select {
+			case <-c.stop:
+				atomic.AddInt64(&c.lost, 1)
+				continue
+			default:
+			}
+			c.report(span)
+		case req := <-c.flushReqs:
+			for n := len(c.spansCh); n > 0; n-- {
+				if span, more := <-c.spansCh; more {
+					c.report(span)
+				}
+			}
+			close(req)
+		case <-c.stop:
+			for range c.spansCh {
+				atomic.AddInt64(&c.lost, 1)
+			}
+			return
+		}
+	}
+}
+
+// drain waits until the spans queued before the call are reported.
+func (c *compositeChild) drain(ctx context.Context) error {
+	if c.spansCh == nil {
+		return nil
+	}
+	req := make(chan struct{})
+	select {
+	case c.flushReqs <- req:
+	case <-c.done:
+		return ErrReporterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-req:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown waits for the queue to be drained, which must be closed, and returns the number of spans left in
+// the queue when the context is done. A span being reported is waited for no longer than the grace period,
+// since the reporter may block, and the spans still queued are then counted as lost.
+func (c *compositeChild) shutdown(ctx context.Context) (int, error) {
+	if c.spansCh == nil {
+		return 0, nil
+	}
+	select {
+	case <-c.done:
+		return 0, nil
+	case <-ctx.Done():
+		close(c.stop)
+		select {
+		case <-c.done:
+			return int(atomic.LoadInt64(&c.lost)), ctx.Err()
+		case <-time.After(shutdownGracePeriod):
+			return int(atomic.LoadInt64(&c.lost)) + len(c.spansCh), ctx.Err()
+		}
+	}
+}
+
+// call calls fn, recovering from panics, and counts the returned error.
+func (c *compositeChild) call(fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			c.panics.Inc(1)
+			err = fmt.Errorf("panic: %v", p)
+		}
 		if err != nil {
-			errStr = errStr + err.Error() + "\n"
+			c.errorsCount.Inc(1)
+			err = fmt.Errorf("%s: %w", c.name, err)
 		}
+	}()
+	return fn()
+}
+
+// parallel calls fn for each reporter from its own goroutine and returns the errors.
+func (c CompositeSpanReporter) parallel(fn func(child *compositeChild) error) error {
+	errs := make([]error, len(c.children))
+	var wg sync.WaitGroup
+	for i, child := range c.children {
+		wg.Add(1)
+		go func(i int, child *compositeChild) {
+			defer wg.Done()
+			errs[i] = child.call(func() error { return fn(child) })
+		}(i, child)
 	}
-	if errStr != "" {
-		return fmt.Errorf(errStr)
+	wg.Wait()
+	return joinErrors(errs)
+}
+
+// Flush reports the queued spans, then flushes the reporters supporting Flush. The reporters are flushed
+// in parallel. It returns a MultiError of the errors of the reporters.
+func (c CompositeSpanReporter) Flush(ctx context.Context) error {
+	return c.parallel(func(child *compositeChild) error {
+		if err := child.drain(ctx); err != nil {
+			return err
+		}
+		if f, ok := child.reporter.(flusher); ok {
+			return f.Flush(ctx)
+		}
+		return nil
+	})
+}
+
+// stopIntake stops accepting spans and closes the queues. It returns false if already stopped.
+func (c CompositeSpanReporter) stopIntake() bool {
+	c.intake.Lock()
+	defer c.intake.Unlock()
+	if c.closed {
+		return false
 	}
-	return nil
+	c.closed = true
+	for _, child := range c.children {
+		if child.spansCh != nil {
+			close(child.spansCh)
+		}
+	}
+	return true
+}
+
+// Shutdown stops accepting spans, reports the queued spans and shuts down the reporters in parallel, calling
+// Close on the reporters not supporting Shutdown. It returns the total number of spans lost, and a MultiError
+// of the errors of the reporters.
+func (c CompositeSpanReporter) Shutdown(ctx context.Context) (int, error) {
+	if !c.stopIntake() {
+		return 0, ErrReporterClosed
+	}
+
+	var mtx sync.Mutex
+	lost := 0
+	err := c.parallel(func(child *compositeChild) error {
+		queueLost, queueErr := child.shutdown(ctx)
+		var childLost int
+		var err error
+		if s, ok := child.reporter.(shutdowner); ok {
+			childLost, err = s.Shutdown(ctx)
+		} else {
+			err = child.reporter.Close()
+		}
+		mtx.Lock()
+		lost += queueLost + childLost
+		mtx.Unlock()
+		if queueErr != nil {
+			return queueErr
+		}
+		return err
+	})
+	return lost, err
+}
+
+// Close reports the queued spans, waiting up to 5 seconds, then closes the reporters in parallel.
+// It returns a MultiError of the errors of the reporters. Closing again has no effect and returns nil.
+func (c CompositeSpanReporter) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.close(ctx)
+}
+
+func (c CompositeSpanReporter) close(ctx context.Context) error {
+	if !c.stopIntake() {
+		return nil
+	}
+	return c.parallel(func(child *compositeChild) error {
+		lost, queueErr := child.shutdown(ctx)
+		err := child.reporter.Close()
+		if queueErr != nil {
+			return joinErrors([]error{fmt.Errorf("timed out closing, %d spans lost", lost), err})
+		}
+		return err
+	})
 }
@@ -0,0 +1,238 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+)
+
+// recordingReporter records spans, optionally blocking, panicking or failing.
+type recordingReporter struct {
+	mtx      sync.Mutex
+	spans    []string
+	block    chan struct{}
+	panics   bool
+	err      error
+	flushed  int
+	shutdown bool
+	closed   bool
+}
+
+func (r *recordingReporter) ReportSpan(span tracer.RawSpan) {
+	if r.block != nil {
+		<-r.block
+	}
+	if r.panics {
+		panic("boom")
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.spans = append(r.spans, span.Operation)
+}
+
+func (r *recordingReporter) spanNames() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]string(nil), r.spans...)
+}
+
+func (r *recordingReporter) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.closed = true
+	return r.err
+}
+
+// flushingReporter is a recordingReporter supporting Flush and Shutdown.
+type flushingReporter struct {
+	recordingReporter
+	lost int
+}
+
+func (r *flushingReporter) Flush(ctx context.Context) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.flushed++
+	return r.err
+}
+
+func (r *flushingReporter) Shutdown(ctx context.Context) (int, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.shutdown = true
+	return r.lost, r.err
+}
+
+func TestCompositeSpanReporter(t *testing.T) {
+	first, second := &recordingReporter{}, &recordingReporter{}
+	r := NewCompositeSpanReporter(first, second)
+	r.ReportSpan(newSpan("a"))
+
+	assert.Equal(t, []string{"a"}, first.spanNames())
+	assert.Equal(t, []string{"a"}, second.spanNames())
+	require.NoError(t, r.Close())
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+	assert.NoError(t, r.Close(), "closing again has no effect")
+}
+
+func TestCompositeSpanReporter_PanicIsolation(t *testing.T) {
+	registry := metrics.NewRegistry()
+	panicking, healthy := &recordingReporter{panics: true}, &recordingReporter{}
+	r := NewCompositeSpanReporterWithOptions([]tracer.SpanReporter{panicking, healthy},
+		CompositeNames("bad", "good"), CompositeMetricsRegistry(registry))
+
+	r.ReportSpan(newSpan("a"))
+	r.ReportSpan(newSpan("b"))
+
+	assert.Equal(t, []string{"a", "b"}, healthy.spanNames())
+	assert.Equal(t, int64(2), registry.Get("bad.panics").(metrics.Counter).Count())
+	assert.Equal(t, int64(2), registry.Get("bad.spans.received").(metrics.Counter).Count())
+	assert.Equal(t, int64(0), registry.Get("good.panics").(metrics.Counter).Count())
+}
+
+func TestCompositeSpanReporter_AsyncSlowReporter(t *testing.T) {
+	slow := &recordingReporter{block: make(chan struct{})}
+	fast := &flushingReporter{}
+	r := NewCompositeSpanReporterWithOptions([]tracer.SpanReporter{slow, fast}, CompositeAsync(10))
+	c := r.(CompositeSpanReporter)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, name := range []string{"a", "b", "c"} {
+			r.ReportSpan(newSpan(name))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReportSpan blocked on the slow reporter")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.Flush(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, "0: context deadline exceeded", err.Error())
+	assert.Equal(t, []string{"a", "b", "c"}, fast.spanNames())
+	assert.Equal(t, 1, fast.flushed)
+
+	close(slow.block)
+	lost, err := c.Shutdown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, lost)
+	assert.Equal(t, []string{"a", "b", "c"}, slow.spanNames())
+	assert.True(t, slow.closed)
+	assert.True(t, fast.shutdown)
+}
+
+func TestCompositeSpanReporter_AsyncQueueFull(t *testing.T) {
+	registry := metrics.NewRegistry()
+	slow := &recordingReporter{block: make(chan struct{})}
+	r := NewCompositeSpanReporterWithOptions([]tracer.SpanReporter{slow},
+		CompositeAsync(1), CompositeNames("slow"), CompositeMetricsRegistry(registry))
+	for _, name := range []string{"a", "b", "c"} {
+		r.ReportSpan(newSpan(name))
+	}
+
+	assert.Equal(t, int64(3), registry.Get("slow.spans.received").(metrics.Counter).Count())
+	assert.True(t, registry.Get("slow.spans.dropped").(metrics.Counter).Count() > 0)
+	assert.Equal(t, int64(1), registry.Get("slow.queue.size").(metrics.Gauge).Value())
+	close(slow.block)
+	require.NoError(t, r.Close())
+}
+
+func TestCompositeSpanReporter_ShutdownTimeout(t *testing.T) {
+	slow := &recordingReporter{block: make(chan struct{})}
+	r := NewCompositeSpanReporterWithOptions([]tracer.SpanReporter{slow}, CompositeAsync(10))
+	c := r.(CompositeSpanReporter)
+	for _, name := range []string{"a", "b", "c"} {
+		r.ReportSpan(newSpan(name))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go func() {
+		// let the reporter finish the span in progress once the queue is stopped
+		<-c.children[0].stop
+		close(slow.block)
+	}()
+	lost, err := c.Shutdown(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 2, lost)
+	assert.Equal(t, []string{"a"}, slow.spanNames())
+	assert.True(t, slow.closed)
+}
+
+func TestCompositeSpanReporter_CloseTimeout(t *testing.T) {
+	errClose := errors.New("close failed")
+	slow := &recordingReporter{block: make(chan struct{}), err: errClose}
+	r := NewCompositeSpanReporterWithOptions([]tracer.SpanReporter{slow}, CompositeAsync(10), CompositeNames("slow"))
+	c := r.(CompositeSpanReporter)
+	for _, name := range []string{"a", "b", "c"} {
+		r.ReportSpan(newSpan(name))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go func() {
+		<-c.children[0].stop
+		close(slow.block)
+	}()
+	err := c.close(ctx)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errClose), "the error of the reporter is kept")
+	assert.Contains(t, err.Error(), "timed out closing, 2 spans lost")
+	assert.NoError(t, r.Close())
+}
+
+func TestCompositeSpanReporter_ShutdownBlockedReporter(t *testing.T) {
+	blocked := &recordingReporter{block: make(chan struct{})}
+	defer close(blocked.block)
+	r := NewCompositeSpanReporterWithOptions([]tracer.SpanReporter{blocked}, CompositeAsync(10))
+	for _, name := range []string{"a", "b", "c"} {
+		r.ReportSpan(newSpan(name))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	lost, err := r.(CompositeSpanReporter).Shutdown(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "the blocked reporter is not waited for")
+	assert.Equal(t, 2, lost, "the queued spans are lost")
+}
+
+func TestCompositeSpanReporter_AggregatedErrors(t *testing.T) {
+	errFirst, errSecond := errors.New("first failed"), errors.New("second failed")
+	first := &flushingReporter{recordingReporter: recordingReporter{err: errFirst}, lost: 3}
+	second := &recordingReporter{err: errSecond}
+	third := &flushingReporter{}
+	r := NewCompositeSpanReporterWithOptions([]tracer.SpanReporter{first, second, third}, CompositeNames("wavefront", "console"))
+	c := r.(CompositeSpanReporter)
+
+	err := c.Flush(context.Background())
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errFirst))
+	assert.False(t, errors.Is(err, errSecond))
+
+	lost, err := c.Shutdown(context.Background())
+	assert.Equal(t, 3, lost)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errFirst))
+	assert.True(t, errors.Is(err, errSecond))
+	assert.Equal(t, "wavefront: first failed\nconsole: second failed", err.Error())
+
+	var multi MultiError
+	require.True(t, errors.As(err, &multi))
+	assert.Len(t, multi, 2)
+	assert.True(t, third.shutdown)
+}
@@ -1,43 +1,374 @@
 package reporter
 
 import (
+	"fmt"
+	"io"
 	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
 	"github.com/wavefronthq/wavefront-sdk-go/senders"
 )
 
-// ConsoleSpanReporter reports spans to STDOUT.
+// ConsoleFormat is the way the ConsoleSpanReporter prints spans.
+type ConsoleFormat int
+
+const (
+	// ConsoleRaw prints spans as Wavefront span lines, followed by their span logs in JSON. This is the default.
+	ConsoleRaw ConsoleFormat = iota
+
+	// ConsoleSummary prints each span on a single human-readable line.
+	ConsoleSummary
+
+	// ConsoleTree prints the spans of each trace as an indented tree once its root span finishes.
+	ConsoleTree
+)
+
+const defaultConsoleTreeTimeout = 30 * time.Second
+
+// ANSI escape codes used to highlight the console output.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// ConsoleOption allows customizing the ConsoleSpanReporter.
+type ConsoleOption func(*ConsoleSpanReporter)
+
+// ConsoleWriter sets the writer spans are printed to. Defaults to the standard logger of the log package.
+func ConsoleWriter(w io.Writer) ConsoleOption {
+	return func(r *ConsoleSpanReporter) {
+		r.writer = w
+	}
+}
+
+// ConsoleOutputFormat sets the way spans are printed. Defaults to ConsoleRaw.
+func ConsoleOutputFormat(format ConsoleFormat) ConsoleOption {
+	return func(r *ConsoleSpanReporter) {
+		r.format = format
+	}
+}
+
+// ConsoleHideUnsampled skips the spans that are not sampled.
+func ConsoleHideUnsampled() ConsoleOption {
+	return func(r *ConsoleSpanReporter) {
+		r.hideUnsampled = true
+	}
+}
+
+// ConsoleColors highlights durations, tags and errors with ANSI colors in the summary and tree formats.
+func ConsoleColors() ConsoleOption {
+	return func(r *ConsoleSpanReporter) {
+		r.colors = true
+	}
+}
+
+// ConsoleTreeTimeout sets how long the spans of a trace are kept waiting for its root span in the tree format,
+// for example when the root span belongs to another process. Defaults to 30 seconds.
+func ConsoleTreeTimeout(timeout time.Duration) ConsoleOption {
+	return func(r *ConsoleSpanReporter) {
+		r.treeTimeout = timeout
+	}
+}
+
+// ConsoleValueEncoding sets the encoder of the values of tags and log fields. Defaults to DefaultValueEncoder.
+func ConsoleValueEncoding(encoder ValueEncoder) ConsoleOption {
+	return func(r *ConsoleSpanReporter) {
+		r.encoder = encoder
+	}
+}
+
+// ConsoleSpanReporter prints spans to the standard logger of the log package, or to the writer set with
+// ConsoleWriter.
 type ConsoleSpanReporter struct {
-	source string
+	source        string
+	writer        io.Writer
+	format        ConsoleFormat
+	hideUnsampled bool
+	colors        bool
+	treeTimeout   time.Duration
+	encoder       ValueEncoder
+
+	mtx    sync.Mutex // protects writer and traces
+	traces map[string]*pendingTrace
+}
+
+// pendingTrace holds the finished spans of a trace until its root span finishes, or the timer fires.
+type pendingTrace struct {
+	spans []tracer.RawSpan
+	timer *time.Timer
 }
 
 // NewConsoleSpanReporter returns a ConsoleSpanReporter.
-func NewConsoleSpanReporter(source string) tracer.SpanReporter {
-	return &ConsoleSpanReporter{source}
+func NewConsoleSpanReporter(source string, options ...ConsoleOption) tracer.SpanReporter {
+	r := &ConsoleSpanReporter{
+		source:      source,
+		treeTimeout: defaultConsoleTreeTimeout,
+		encoder:     DefaultValueEncoder,
+		traces:      make(map[string]*pendingTrace),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
 }
 
 // ReportSpan complies with the `tracer.SpanReporter` interface.
 func (r *ConsoleSpanReporter) ReportSpan(span tracer.RawSpan) {
-	decision := !span.Context.IsSampled() || *span.Context.SamplingDecision()
+	if !isSampled(span) && r.hideUnsampled {
+		return
+	}
+
+	switch r.format {
+	case ConsoleSummary:
+		r.print(r.summary(span))
+	case ConsoleTree:
+		r.addToTree(span)
+	default:
+		r.printRaw(span)
+	}
+}
+
+func (r *ConsoleSpanReporter) printRaw(span tracer.RawSpan) {
 	sampled := ""
-	if !decision {
+	if !isSampled(span) {
 		sampled = " [not sampled]"
 	}
 
-	tags := prepareTags(span)
+	tags := prepareTags(span, r.encoder)
 	parents, followsFrom := prepareReferences(span)
+	logs := prepareLogs(span, r.encoder)
 
 	line, err := senders.SpanLine(span.Operation, span.Start.UnixNano()/1000000, span.Duration.Nanoseconds()/1000000, r.source,
-		span.Context.TraceID, span.Context.SpanID, parents, followsFrom, tags, nil, "")
-
+		span.Context.TraceID, span.Context.SpanID, parents, followsFrom, tags, logs, "")
 	if err != nil {
 		log.Printf("SpanLine Error: %v", err)
-	} else {
+		return
+	}
+	if len(logs) > 0 {
+		logsLine, err := senders.SpanLogJSON(span.Context.TraceID, span.Context.SpanID, logs)
+		if err != nil {
+			log.Printf("SpanLogs Error: %v", err)
+		} else {
+			line += logsLine
+		}
+	}
+
+	if r.writer == nil {
 		log.Printf("SpanLine%s: %v", sampled, line)
+		return
 	}
+	r.print(line)
 }
 
+// summary returns a single line describing the span.
+func (r *ConsoleSpanReporter) summary(span tracer.RawSpan) string {
+	var sb strings.Builder
+	sb.WriteString(span.Start.Format(time.RFC3339Nano))
+	sb.WriteString(" ")
+	sb.WriteString(span.Operation)
+	sb.WriteString(" ")
+	sb.WriteString(r.duration(span.Duration))
+	sb.WriteString(" traceId=")
+	sb.WriteString(span.Context.TraceID)
+	sb.WriteString(" spanId=")
+	sb.WriteString(span.Context.SpanID)
+	parents, followsFrom := prepareReferences(span)
+	for _, parent := range parents {
+		sb.WriteString(" parent=")
+		sb.WriteString(parent)
+	}
+	for _, item := range followsFrom {
+		sb.WriteString(" followsFrom=")
+		sb.WriteString(item)
+	}
+	r.writeMarkers(&sb, span)
+	r.writeTags(&sb, span)
+	if len(span.Logs) > 0 {
+		fmt.Fprintf(&sb, " logs=%d", len(span.Logs))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func (r *ConsoleSpanReporter) addToTree(span tracer.RawSpan) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	traceID := span.Context.TraceID
+	if len(span.References) == 0 {
+		spans := []tracer.RawSpan{span}
+		if trace, found := r.traces[traceID]; found {
+			trace.timer.Stop()
+			spans = append(trace.spans, span)
+			delete(r.traces, traceID)
+		}
+		r.printTree(traceID, spans)
+		return
+	}
+
+	trace, found := r.traces[traceID]
+	if !found {
+		// print the trace even if its root span is not coming, and no other span is reported
+		trace = &pendingTrace{}
+		trace.timer = time.AfterFunc(r.treeTimeout, func() { r.expireTree(traceID, trace) })
+		r.traces[traceID] = trace
+	}
+	trace.spans = append(trace.spans, span)
+}
+
+// expireTree prints the spans of a trace whose root span did not finish before the timeout.
+func (r *ConsoleSpanReporter) expireTree(traceID string, trace *pendingTrace) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	// the trace may have been printed since the timer fired
+	if r.traces[traceID] != trace {
+		return
+	}
+	r.printTree(traceID, trace.spans)
+	delete(r.traces, traceID)
+}
+
+// printTree prints the spans of a trace as a tree. Spans whose parent is not in the trace are printed as roots.
+// Must be called with mtx held.
+func (r *ConsoleSpanReporter) printTree(traceID string, spans []tracer.RawSpan) {
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+
+	ids := make(map[string]bool, len(spans))
+	for _, span := range spans {
+		ids[span.Context.SpanID] = true
+	}
+	children := make(map[string][]tracer.RawSpan)
+	var roots []tracer.RawSpan
+	for _, span := range spans {
+		parent := parentSpanID(span)
+		if parent != "" && ids[parent] && parent != span.Context.SpanID {
+			children[parent] = append(children[parent], span)
+		} else {
+			roots = append(roots, span)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "trace %s (%d spans)\n", traceID, len(spans))
+	var writeSpan func(span tracer.RawSpan, depth int)
+	writeSpan = func(span tracer.RawSpan, depth int) {
+		indent := strings.Repeat("  ", depth)
+		sb.WriteString(indent)
+		sb.WriteString(span.Operation)
+		sb.WriteString(" ")
+		sb.WriteString(r.duration(span.Duration))
+		r.writeMarkers(&sb, span)
+		r.writeTags(&sb, span)
+		sb.WriteString("\n")
+		for _, lr := range span.Logs {
+			sb.WriteString(indent)
+			sb.WriteString("  · +")
+			sb.WriteString(r.duration(lr.Timestamp.Sub(span.Start)))
+			for _, field := range lr.Fields {
+				sb.WriteString(" ")
+				sb.WriteString(field.Key())
+				sb.WriteString("=")
+				sb.WriteString(r.encoder(field.Value()))
+			}
+			sb.WriteString("\n")
+		}
+		for _, child := range children[span.Context.SpanID] {
+			writeSpan(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		writeSpan(root, 1)
+	}
+	r.write(sb.String())
+}
+
+// parentSpanID returns the ID of the span referenced by the first ChildOf reference, or "" if there is none.
+func parentSpanID(span tracer.RawSpan) string {
+	if i := parentIndex(span); i >= 0 {
+		if refCtx, ok := span.References[i].ReferencedContext.(tracer.SpanContext); ok {
+			return refCtx.SpanID
+		}
+	}
+	return ""
+}
+
+func (r *ConsoleSpanReporter) writeMarkers(sb *strings.Builder, span tracer.RawSpan) {
+	if hasTrueTag(string(ext.Error), span.Tags) {
+		sb.WriteString(" ")
+		sb.WriteString(r.highlight(ansiRed, "[ERROR]"))
+	}
+	if !isSampled(span) {
+		sb.WriteString(" [not sampled]")
+	}
+}
+
+func (r *ConsoleSpanReporter) writeTags(sb *strings.Builder, span tracer.RawSpan) {
+	keys := make([]string, 0, len(span.Tags))
+	for k := range span.Tags {
+		if k != string(ext.Error) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sb.WriteString(" ")
+		sb.WriteString(r.highlight(ansiYellow, k))
+		sb.WriteString("=")
+		sb.WriteString(r.encoder(span.Tags[k]))
+		for _, additional := range span.AdditionalTags[k] {
+			sb.WriteString(",")
+			sb.WriteString(r.encoder(additional))
+		}
+	}
+}
+
+func (r *ConsoleSpanReporter) duration(d time.Duration) string {
+	return r.highlight(ansiCyan, d.Round(time.Microsecond).String())
+}
+
+func (r *ConsoleSpanReporter) highlight(color, s string) string {
+	if !r.colors {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+func (r *ConsoleSpanReporter) print(s string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.write(s)
+}
+
+// write must be called with mtx held.
+func (r *ConsoleSpanReporter) write(s string) {
+	if r.writer == nil {
+		log.Print(s)
+		return
+	}
+	if _, err := io.WriteString(r.writer, s); err != nil {
+		log.Printf("console reporter error: %v", err)
+	}
+}
+
+func isSampled(span tracer.RawSpan) bool {
+	return !span.Context.IsSampled() || *span.Context.SamplingDecision()
+}
+
+// Close prints the traces still waiting for their root span in the tree format.
 func (r *ConsoleSpanReporter) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for traceID, trace := range r.traces {
+		trace.timer.Stop()
+		r.printTree(traceID, trace.spans)
+		delete(r.traces, traceID)
+	}
 	return nil
 }
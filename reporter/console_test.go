@@ -0,0 +1,156 @@
+package reporter
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsoleSpanReporter_Raw(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewConsoleSpanReporter("host", ConsoleWriter(&buf))
+
+	span := newSpan("op")
+	span.Logs = []opentracing.LogRecord{{Timestamp: span.Start, Fields: []log.Field{log.String("event", "retry")}}}
+	r.ReportSpan(span)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], `"op" source="host" traceId=`+span.Context.TraceID), lines[0])
+	assert.Contains(t, lines[0], `"_spanLogs"="true"`)
+	assert.Contains(t, lines[1], `"event":"retry"`)
+}
+
+func TestConsoleSpanReporter_Summary(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewConsoleSpanReporter("host", ConsoleWriter(&buf), ConsoleOutputFormat(ConsoleSummary), ConsoleHideUnsampled())
+
+	span := newSpan("op")
+	span.Duration = 1500 * time.Microsecond
+	span.Tags = opentracing.Tags{"error": true, "http.status_code": 500}
+	r.ReportSpan(span)
+
+	decision := false
+	unsampled := newSpan("unsampled")
+	unsampled.Context.Sampled = &decision
+	r.ReportSpan(unsampled)
+
+	out := buf.String()
+	assert.Equal(t, 1, strings.Count(out, "\n"))
+	assert.Contains(t, out, " op 1.5ms traceId="+span.Context.TraceID)
+	assert.Contains(t, out, "[ERROR] http.status_code=500")
+	assert.NotContains(t, out, "unsampled")
+}
+
+func TestConsoleSpanReporter_ValueEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewConsoleSpanReporter("host", ConsoleWriter(&buf), ConsoleOutputFormat(ConsoleSummary))
+	span := newSpan("op")
+	span.Tags = opentracing.Tags{"ids": []int{1, 2}, "ratio": 0.25}
+	span.Logs = []opentracing.LogRecord{{Timestamp: span.Start, Fields: []log.Field{log.Error(errors.New("timeout"))}}}
+	r.ReportSpan(span)
+	assert.Contains(t, buf.String(), `ids=[1,2] ratio=0.25`)
+
+	buf.Reset()
+	upper := func(value interface{}) string { return strings.ToUpper(DefaultValueEncoder(value)) }
+	r = NewConsoleSpanReporter("host", ConsoleWriter(&buf), ConsoleValueEncoding(upper))
+	r.ReportSpan(span)
+	assert.Contains(t, buf.String(), `"error.object":"TIMEOUT"`)
+	assert.Contains(t, buf.String(), `"ids"="[1,2]"`)
+}
+
+func TestConsoleSpanReporter_Tree(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewConsoleSpanReporter("host", ConsoleWriter(&buf), ConsoleOutputFormat(ConsoleTree), ConsoleColors())
+
+	root := newSpan("root")
+	child := newSpan("child")
+	child.Context.TraceID = root.Context.TraceID
+	child.References = []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: root.Context}}
+	child.Logs = []opentracing.LogRecord{{Timestamp: child.Start.Add(time.Millisecond), Fields: []log.Field{log.String("event", "cache miss")}}}
+	grandchild := newSpan("grandchild")
+	grandchild.Context.TraceID = root.Context.TraceID
+	grandchild.References = []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: child.Context}}
+	grandchild.Tags = opentracing.Tags{"error": true}
+
+	r.ReportSpan(grandchild)
+	r.ReportSpan(child)
+	assert.Empty(t, buf.String(), "trace printed before its root span finished")
+	r.ReportSpan(root)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, "trace "+root.Context.TraceID+" (3 spans)", lines[0])
+	assert.Equal(t, "  root "+ansiCyan+"1ms"+ansiReset, lines[1])
+	assert.True(t, strings.HasPrefix(lines[2], "    child "))
+	assert.Equal(t, "      · +"+ansiCyan+"1ms"+ansiReset+" event=cache miss", lines[3])
+	assert.Equal(t, "      grandchild "+ansiCyan+"1ms"+ansiReset+" "+ansiRed+"[ERROR]"+ansiReset, lines[4])
+}
+
+func TestConsoleSpanReporter_TreeFollowsFrom(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewConsoleSpanReporter("host", ConsoleWriter(&buf), ConsoleOutputFormat(ConsoleTree))
+
+	root := newSpan("root")
+	previous := newSpan("previous")
+	previous.Context.TraceID = root.Context.TraceID
+	previous.References = []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: root.Context}}
+	next := newSpan("next")
+	next.Context.TraceID = root.Context.TraceID
+	next.Start = previous.Start.Add(time.Millisecond)
+	next.References = []opentracing.SpanReference{
+		{Type: opentracing.FollowsFromRef, ReferencedContext: previous.Context},
+		{Type: opentracing.ChildOfRef, ReferencedContext: root.Context},
+	}
+
+	r.ReportSpan(previous)
+	r.ReportSpan(next)
+	r.ReportSpan(root)
+	assert.Equal(t, "trace "+root.Context.TraceID+" (3 spans)\n  root 1ms\n    previous 1ms\n    next 1ms\n",
+		buf.String(), "the span is nested under its ChildOf reference")
+}
+
+func TestConsoleSpanReporter_TreeWithoutRoot(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewConsoleSpanReporter("host", ConsoleWriter(&buf), ConsoleOutputFormat(ConsoleTree))
+
+	remote := newSpan("remote")
+	span := newSpan("server")
+	span.Context.TraceID = remote.Context.TraceID
+	span.References = []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: remote.Context}}
+	r.ReportSpan(span)
+	assert.Empty(t, buf.String())
+
+	require.NoError(t, r.Close())
+	assert.Equal(t, "trace "+remote.Context.TraceID+" (1 spans)\n  server 1ms\n", buf.String())
+}
+
+func TestConsoleSpanReporter_TreeTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewConsoleSpanReporter("host", ConsoleWriter(&buf), ConsoleOutputFormat(ConsoleTree),
+		ConsoleTreeTimeout(10*time.Millisecond))
+	output := func() string {
+		r.(*ConsoleSpanReporter).mtx.Lock()
+		defer r.(*ConsoleSpanReporter).mtx.Unlock()
+		return buf.String()
+	}
+
+	remote := newSpan("remote")
+	span := newSpan("server")
+	span.Context.TraceID = remote.Context.TraceID
+	span.References = []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: remote.Context}}
+	r.ReportSpan(span)
+
+	want := "trace " + remote.Context.TraceID + " (1 spans)\n  server 1ms\n"
+	assert.Eventually(t, func() bool { return output() == want }, time.Second, time.Millisecond,
+		"the trace is printed after the timeout without another span reported")
+	require.NoError(t, r.Close())
+	assert.Equal(t, want, output(), "the trace is printed once")
+}
@@ -0,0 +1,64 @@
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// ValueEncoder encodes the value of a span tag or log field as a string, for the formats where values are strings.
+type ValueEncoder func(value interface{}) string
+
+// DefaultValueEncoder encodes errors with their Error method, times in RFC 3339 format, floats in their shortest
+// representation, and maps, slices, arrays and structs in JSON. Other values are formatted with fmt.Sprint.
+func DefaultValueEncoder(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case float64:
+		return formatFloat(v, 64)
+	case float32:
+		return formatFloat(float64(v), 32)
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Ptr:
+		if b, err := json.Marshal(value); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// formatFloat formats the float in its shortest representation, using exponents for large and small values
+// like JSON does.
+func formatFloat(f float64, bits int) string {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	return strconv.FormatFloat(f, format, -1, bits)
+}
+
+// typedValue returns the booleans and numbers kept as such by the Jaeger and OTLP formats, and encodes the
+// other values.
+func typedValue(value interface{}, encode ValueEncoder) interface{} {
+	switch value.(type) {
+	case bool, int, int8, int16, int32, int64, uint8, uint16, uint32, float32, float64:
+		return value
+	}
+	return encode(value)
+}
@@ -0,0 +1,56 @@
+package reporter
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type status int
+
+func TestDefaultValueEncoder(t *testing.T) {
+	for _, test := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{"text", "text"},
+		{true, "true"},
+		{42, "42"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{0.1, "0.1"},
+		{float32(0.1), "0.1"},
+		{1e21, "1e+21"},
+		{1e-7, "1e-07"},
+		{100.0, "100"},
+		{math.Inf(-1), "-Inf"},
+		{errors.New("failed"), "failed"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC), "2020-01-02T03:04:05.006Z"},
+		{1500 * time.Millisecond, "1.5s"},
+		{map[string]int{"b": 2, "a": 1}, `{"a":1,"b":2}`},
+		{[]string{"a", "b"}, `["a","b"]`},
+		{[2]int{1, 2}, "[1,2]"},
+		{point{1, 2}, `{"x":1,"y":2}`},
+		{&point{1, 2}, `{"x":1,"y":2}`},
+		{status(3), "3"},
+		{[]float64{math.NaN()}, "[NaN]"}, // not supported by JSON
+	} {
+		assert.Equal(t, test.expected, DefaultValueEncoder(test.value), "%T %v", test.value, test.value)
+	}
+}
+
+func TestTypedValue(t *testing.T) {
+	assert.Equal(t, 42, typedValue(42, DefaultValueEncoder))
+	assert.Equal(t, 0.5, typedValue(0.5, DefaultValueEncoder))
+	assert.Equal(t, true, typedValue(true, DefaultValueEncoder))
+	assert.Equal(t, "[1,2]", typedValue([]int{1, 2}, DefaultValueEncoder))
+	assert.Equal(t, "18446744073709551615", typedValue(uint64(math.MaxUint64), DefaultValueEncoder))
+}
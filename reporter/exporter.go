@@ -0,0 +1,461 @@
+package reporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+)
+
+type exporterConfig struct {
+	bufferSize    int
+	batchSize     int
+	flushInterval time.Duration
+	retry         *RetryPolicy
+	client        *http.Client
+	headers       map[string]string
+	gzip          bool
+	maxPacketSize int
+	registry      metrics.Registry
+	encoder       ValueEncoder
+}
+
+// ExporterOption allows customizing the span reporters exporting spans to other tracing backends.
+type ExporterOption func(*exporterConfig)
+
+// ExporterBufferSize sets the size of the in-memory buffer. Incoming spans are dropped if buffer is full.
+// Defaults to 50,000.
+func ExporterBufferSize(size int) ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.bufferSize = size
+	}
+}
+
+// ExporterBatchSize sets the maximum number of spans exported at once. Defaults to 512.
+func ExporterBatchSize(size int) ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.batchSize = size
+	}
+}
+
+// ExporterFlushInterval sets how often buffered spans are exported when the batch is not full. Defaults to 5 seconds.
+func ExporterFlushInterval(interval time.Duration) ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.flushInterval = interval
+	}
+}
+
+// ExporterRetry enables retrying failed batch exports according to the given policy.
+// Unless the policy sets Retryable, network errors and 429, 502, 503 and 504 responses are retried.
+func ExporterRetry(policy RetryPolicy) ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.retry = &policy
+	}
+}
+
+// ExporterHTTPClient sets the client used by HTTP exporters. Defaults to a client with a 10 second timeout.
+func ExporterHTTPClient(client *http.Client) ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.client = client
+	}
+}
+
+// ExporterHeader adds a header to the requests made by HTTP exporters.
+func ExporterHeader(key, value string) ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.headers[key] = value
+	}
+}
+
+// ExporterGzip enables gzip compression of the requests made by HTTP exporters.
+func ExporterGzip() ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.gzip = true
+	}
+}
+
+// ExporterMaxPacketSize sets the maximum size in bytes of the packets sent by UDP exporters. Batches are split
+// to fit in a packet. Defaults to 65,000.
+func ExporterMaxPacketSize(size int) ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.maxPacketSize = size
+	}
+}
+
+// ExporterMetricsRegistry sets the registry of the reporter's internal metrics, which follow the names used by
+// the Wavefront span reporter: spans.received, spans.dropped, errors, queue.size and queue.remaining_capacity.
+// Defaults to a new registry.
+func ExporterMetricsRegistry(registry metrics.Registry) ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.registry = registry
+	}
+}
+
+// ExporterValueEncoding sets the encoder of the values of tags and log fields that are exported as strings.
+// The Jaeger and OTLP reporters keep booleans and numbers as such, and the file reporter keeps the type of
+// all the values. Defaults to DefaultValueEncoder.
+func ExporterValueEncoding(encoder ValueEncoder) ExporterOption {
+	return func(cfg *exporterConfig) {
+		cfg.encoder = encoder
+	}
+}
+
+func newExporterConfig(options []ExporterOption) exporterConfig {
+	cfg := exporterConfig{
+		bufferSize:    50000,
+		batchSize:     512,
+		flushInterval: 5 * time.Second,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		headers:       make(map[string]string),
+		maxPacketSize: defaultMaxPacketSize,
+		encoder:       DefaultValueEncoder,
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+	if cfg.registry == nil {
+		cfg.registry = metrics.NewRegistry()
+	}
+	if cfg.batchSize <= 0 {
+		cfg.batchSize = 1
+	}
+	if cfg.retry != nil && cfg.retry.Retryable == nil {
+		cfg.retry.Retryable = isRetryableHTTP
+	}
+	return cfg
+}
+
+// batcher queues spans and hands them in batches to an export function from a single goroutine.
+// It implements tracer.SpanReporter along with Flush and Shutdown for the exporting span reporters.
+type batcher struct {
+	cfg    exporterConfig
+	name   string
+	export func(spans []tracer.RawSpan) error
+
+	spansCh   chan tracer.RawSpan
+	flushReqs chan chan error
+	stop      chan struct{}
+	done      chan struct{}
+	intake    sync.RWMutex // guards closing spansCh
+	closed    bool
+	closer    func() error // releases the resources of the exporter once the queue is drained
+
+	spansReceived metrics.Counter
+	spansDropped  metrics.Counter
+	errorsCount   metrics.Counter
+
+	mtx         sync.Mutex // protects the fields below
+	failures    int
+	lastFailure error
+	lost        int
+	dropped     int // spans dropped since the last log
+	lastDropLog time.Time
+}
+
+// dropLogInterval is the minimum interval between the logs of dropped spans.
+const dropLogInterval = 10 * time.Second
+
+func newBatcher(name string, cfg exporterConfig, export func(spans []tracer.RawSpan) error) *batcher {
+	b := &batcher{
+		cfg:       cfg,
+		name:      name,
+		export:    export,
+		spansCh:   make(chan tracer.RawSpan, cfg.bufferSize),
+		flushReqs: make(chan chan error),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	b.spansReceived = metrics.GetOrRegisterCounter("spans.received", cfg.registry)
+	b.spansDropped = metrics.GetOrRegisterCounter("spans.dropped", cfg.registry)
+	b.errorsCount = metrics.GetOrRegisterCounter("errors", cfg.registry)
+	cfg.registry.GetOrRegister("queue.size", metrics.NewFunctionalGauge(func() int64 {
+		return int64(len(b.spansCh))
+	}))
+	cfg.registry.GetOrRegister("queue.remaining_capacity", metrics.NewFunctionalGauge(func() int64 {
+		return int64(cap(b.spansCh) - len(b.spansCh))
+	}))
+	go b.process()
+	return b
+}
+
+// ReportSpan complies with the tracer.SpanReporter interface.
+func (b *batcher) ReportSpan(span tracer.RawSpan) {
+	if span.Context.IsSampled() && !*span.Context.SamplingDecision() {
+		return
+	}
+
+	b.intake.RLock()
+	defer b.intake.RUnlock()
+	if b.closed {
+		b.spansDropped.Inc(1)
+		return
+	}
+	b.spansReceived.Inc(1)
+	select {
+	case b.spansCh <- span:
+	default:
+		b.spansDropped.Inc(1)
+		b.logDropped()
+	}
+}
+
+// logDropped counts a span dropped because the buffer is full, logging the dropped spans at most once
+// per interval.
+func (b *batcher) logDropped() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.dropped++
+	if now := time.Now(); now.Sub(b.lastDropLog) >= dropLogInterval {
+		log.Printf("%s buffer full, dropped %d spans", b.name, b.dropped)
+		b.dropped, b.lastDropLog = 0, now
+	}
+}
+
+func (b *batcher) process() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.cfg.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]tracer.RawSpan, 0, b.cfg.batchSize)
+	for {
+		select {
+		case span, more := <-b.spansCh:
+			if !more {
+				b.exportBatch(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= b.cfg.batchSize {
+				b.exportBatch(batch)
+				batch = make([]tracer.RawSpan, 0, b.cfg.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				b.exportBatch(batch)
+				batch = make([]tracer.RawSpan, 0, b.cfg.batchSize)
+			}
+		case req := <-b.flushReqs:
+			for n := len(b.spansCh); n > 0; n-- {
+				batch = append(batch, <-b.spansCh)
+				if len(batch) >= b.cfg.batchSize {
+					b.exportBatch(batch)
+					batch = make([]tracer.RawSpan, 0, b.cfg.batchSize)
+				}
+			}
+			if len(batch) > 0 {
+				b.exportBatch(batch)
+				batch = make([]tracer.RawSpan, 0, b.cfg.batchSize)
+			}
+			req <- b.takeFailures()
+		}
+	}
+}
+
+func (b *batcher) exportBatch(batch []tracer.RawSpan) {
+	if len(batch) == 0 {
+		return
+	}
+
+	var err error
+	select {
+	case <-b.stop:
+		err = errShutdownTimeout
+	default:
+		if b.cfg.retry == nil {
+			err = b.export(batch)
+		} else {
+			_, err = b.cfg.retry.do(b.stop, func() error { return b.export(batch) })
+		}
+	}
+	if err == nil {
+		return
+	}
+	b.errorsCount.Inc(int64(len(batch)))
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.failures += len(batch)
+	b.lastFailure = err
+	if b.closed {
+		b.lost += len(batch)
+	}
+	if err != errShutdownTimeout {
+		log.Printf("%s error exporting %d spans: %v", b.name, len(batch), err)
+	}
+}
+
+func logSkippedSpan(span tracer.RawSpan, err error) {
+	log.Printf("skipping span: %s error: %v", span.Operation, err)
+}
+
+// takeFailures returns an error describing the spans that failed to export since the last call.
+func (b *batcher) takeFailures() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.failures == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%s failed to export %d spans: %v", b.name, b.failures, b.lastFailure)
+	b.failures, b.lastFailure = 0, nil
+	return err
+}
+
+// Flush exports the spans reported before the call. It returns an error if any span failed to export
+// since the previous flush.
+func (b *batcher) Flush(ctx context.Context) error {
+	req := make(chan error, 1)
+	select {
+	case b.flushReqs <- req:
+	case <-b.done:
+		return ErrReporterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops accepting spans and exports the queued spans. Spans still queued or failing to export
+// when the context is done are lost. It returns the number of lost spans.
+func (b *batcher) Shutdown(ctx context.Context) (int, error) {
+	b.intake.Lock()
+	if b.closed {
+		b.intake.Unlock()
+		return 0, ErrReporterClosed
+	}
+	b.mtx.Lock()
+	b.closed = true
+	b.mtx.Unlock()
+	close(b.spansCh)
+	b.intake.Unlock()
+
+	var shutdownErr error
+	select {
+	case <-b.done:
+		close(b.stop)
+	case <-ctx.Done():
+		shutdownErr = ctx.Err()
+		// abort pending retries. An export in progress is waited for no longer than the grace period, since
+		// it may block; the spans still queued are then lost.
+		close(b.stop)
+		select {
+		case <-b.done:
+		case <-time.After(shutdownGracePeriod):
+			b.mtx.Lock()
+			lost := b.lost + len(b.spansCh)
+			b.mtx.Unlock()
+			log.Printf("%s shut down, %d spans lost", b.name, lost)
+			if b.closer != nil {
+				// the resources of the exporter are released once the blocked export returns
+				go func() {
+					<-b.done
+					b.closer()
+				}()
+			}
+			return lost, shutdownErr
+		}
+	}
+
+	if b.closer != nil {
+		if err := b.closer(); err != nil && shutdownErr == nil {
+			shutdownErr = err
+		}
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.lost > 0 {
+		log.Printf("%s shut down, %d spans lost", b.name, b.lost)
+	}
+	return b.lost, shutdownErr
+}
+
+// Close shuts down the reporter, waiting up to 5 seconds for the queued spans to be exported.
+func (b *batcher) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lost, err := b.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("timed out closing %s, %d spans lost", b.name, lost)
+	}
+	return err
+}
+
+// httpStatusError is returned by HTTP exporters when the backend responds with an unexpected status code.
+type httpStatusError struct {
+	code int
+	body string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.code, e.body)
+}
+
+// isRetryableHTTP reports whether a failed HTTP export may succeed when retried.
+// Errors other than unexpected status codes come from the network.
+func isRetryableHTTP(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(*httpStatusError); ok {
+		switch e.code {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// post sends the body to the url, compressed if gzip is enabled.
+func (cfg exporterConfig) post(url, contentType string, body []byte) error {
+	var reader io.Reader = bytes.NewReader(body)
+	if cfg.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		reader = &buf
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if cfg.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range cfg.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := cfg.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &httpStatusError{code: resp.StatusCode, body: string(msg)}
+	}
+	return nil
+}
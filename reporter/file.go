@@ -0,0 +1,217 @@
+package reporter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+)
+
+const (
+	defaultFileMaxSize       = 100 * 1024 * 1024
+	defaultFileFlushInterval = time.Second
+	rotatedFileTimeFormat    = "20060102T150405.000000000"
+)
+
+type fileConfig struct {
+	maxSize  int64
+	maxAge   time.Duration
+	compress bool
+	exporter []ExporterOption
+}
+
+// FileOption allows customizing the file span reporter.
+type FileOption func(*fileConfig)
+
+// FileMaxSize sets the size in bytes after which the file is rotated. Defaults to 100MB. Zero disables rotating by size.
+func FileMaxSize(size int64) FileOption {
+	return func(cfg *fileConfig) {
+		cfg.maxSize = size
+	}
+}
+
+// FileMaxAge sets the age after which the file is rotated. Rotating by age is disabled by default.
+func FileMaxAge(age time.Duration) FileOption {
+	return func(cfg *fileConfig) {
+		cfg.maxAge = age
+	}
+}
+
+// FileCompress enables gzip compression of the rotated files.
+func FileCompress() FileOption {
+	return func(cfg *fileConfig) {
+		cfg.compress = true
+	}
+}
+
+// FileBufferSize sets the size of the in-memory buffer. Incoming spans are dropped if buffer is full.
+// Defaults to 50,000.
+func FileBufferSize(size int) FileOption {
+	return func(cfg *fileConfig) {
+		cfg.exporter = append(cfg.exporter, ExporterBufferSize(size))
+	}
+}
+
+// FileFlushInterval sets how often buffered spans are written to the file. Defaults to 1 second.
+func FileFlushInterval(interval time.Duration) FileOption {
+	return func(cfg *fileConfig) {
+		cfg.exporter = append(cfg.exporter, ExporterFlushInterval(interval))
+	}
+}
+
+// FileMetricsRegistry sets the registry of the reporter's internal metrics. See ExporterMetricsRegistry.
+func FileMetricsRegistry(registry metrics.Registry) FileOption {
+	return func(cfg *fileConfig) {
+		cfg.exporter = append(cfg.exporter, ExporterMetricsRegistry(registry))
+	}
+}
+
+type fileReporter struct {
+	*batcher
+	cfg  fileConfig
+	path string
+
+	// only used by the batcher goroutine, then by Shutdown
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	opened  time.Time
+	gzipped sync.WaitGroup
+}
+
+// NewFileSpanReporter returns a SpanReporter writing spans to the file at the given path as JSON lines,
+// one span per line, in the encoding of tracer.RawSpan.MarshalJSON. Spans are buffered and written
+// asynchronously. The file is rotated by renaming it with a timestamp suffix, for example
+// "spans.jsonl.20240102T150405.000000000", once it exceeds its maximum size or age.
+func NewFileSpanReporter(path string, options ...FileOption) (tracer.SpanReporter, error) {
+	cfg := fileConfig{maxSize: defaultFileMaxSize}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	r := &fileReporter{cfg: cfg, path: path}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	exporterOptions := append([]ExporterOption{ExporterFlushInterval(defaultFileFlushInterval)}, cfg.exporter...)
+	r.batcher = newBatcher("file reporter", newExporterConfig(exporterOptions), r.export)
+	r.batcher.closer = r.close
+	return r, nil
+}
+
+func (r *fileReporter) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.writer = bufio.NewWriter(file)
+	r.size = info.Size()
+	r.opened = time.Now()
+	return nil
+}
+
+func (r *fileReporter) export(spans []tracer.RawSpan) error {
+	for _, span := range spans {
+		line, err := json.Marshal(span)
+		if err != nil {
+			logSkippedSpan(span, err)
+			continue
+		}
+		line = append(line, '\n')
+
+		if r.size > 0 && r.shouldRotate(len(line)) {
+			if err := r.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := r.writer.Write(line)
+		r.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return r.writer.Flush()
+}
+
+func (r *fileReporter) shouldRotate(next int) bool {
+	if r.cfg.maxSize > 0 && r.size+int64(next) > r.cfg.maxSize {
+		return true
+	}
+	return r.cfg.maxAge > 0 && time.Since(r.opened) >= r.cfg.maxAge
+}
+
+func (r *fileReporter) rotate() error {
+	if err := r.writer.Flush(); err != nil {
+		return err
+	}
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	rotated := r.path + "." + time.Now().Format(rotatedFileTimeFormat)
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	if r.cfg.compress {
+		r.gzipped.Add(1)
+		go func() {
+			defer r.gzipped.Done()
+			if err := gzipFile(rotated); err != nil {
+				log.Printf("file reporter error compressing %s: %v", rotated, err)
+			}
+		}()
+	}
+	return r.open()
+}
+
+// close flushes and closes the file, then waits for the rotated files to be compressed.
+func (r *fileReporter) close() error {
+	err := r.writer.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.gzipped.Wait()
+	return err
+}
+
+// gzipFile replaces the file with a gzip compressed copy with a ".gz" suffix.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(path)
+}
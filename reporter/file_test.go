@@ -0,0 +1,106 @@
+package reporter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+)
+
+func readJSONSpans(t *testing.T, r io.Reader) []tracer.RawSpan {
+	var spans []tracer.RawSpan
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var span tracer.RawSpan
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &span))
+		spans = append(spans, span)
+	}
+	require.NoError(t, scanner.Err())
+	return spans
+}
+
+func TestFileSpanReporter(t *testing.T) {
+	dir := tempSpillDir(t)
+	path := filepath.Join(dir, "spans.jsonl")
+	registry := metrics.NewRegistry()
+	r, err := NewFileSpanReporter(path, FileMetricsRegistry(registry))
+	require.NoError(t, err)
+
+	parent := newSpan("parent")
+	child := newSpan("child")
+	child.References = []opentracing.SpanReference{{Type: opentracing.FollowsFromRef, ReferencedContext: parent.Context}}
+	child.Tags = opentracing.Tags{"http.status_code": 200, "ratio": math.NaN()}
+	decision := false
+	unsampled := newSpan("unsampled")
+	unsampled.Context.Sampled = &decision
+
+	r.ReportSpan(parent)
+	r.ReportSpan(child)
+	r.ReportSpan(unsampled)
+	require.NoError(t, r.(*fileReporter).Flush(context.Background()))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	spans := readJSONSpans(t, file)
+	file.Close()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "parent", spans[0].Operation)
+	assert.Equal(t, parent.Context, spans[0].Context)
+	assert.Equal(t, time.Millisecond, spans[0].Duration)
+	assert.Equal(t, child.References, spans[1].References)
+	assert.Equal(t, 200, spans[1].Tags["http.status_code"])
+	assert.True(t, math.IsNaN(spans[1].Tags["ratio"].(float64)))
+
+	require.NoError(t, r.Close())
+	r.ReportSpan(newSpan("late"))
+	assert.Equal(t, int64(2), registry.Get("spans.received").(metrics.Counter).Count())
+	assert.Equal(t, int64(1), registry.Get("spans.dropped").(metrics.Counter).Count())
+}
+
+func TestFileSpanReporter_Rotation(t *testing.T) {
+	dir := tempSpillDir(t)
+	path := filepath.Join(dir, "spans.jsonl")
+	r, err := NewFileSpanReporter(path, FileMaxSize(1000), FileCompress())
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		r.ReportSpan(newSpan("operation"))
+	}
+	require.NoError(t, r.Close())
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.True(t, len(files) > 2)
+
+	total := 0
+	for _, info := range files {
+		file, err := os.Open(filepath.Join(dir, info.Name()))
+		require.NoError(t, err)
+		var reader io.Reader = file
+		if info.Name() != "spans.jsonl" {
+			require.True(t, strings.HasSuffix(info.Name(), ".gz"), info.Name())
+			zr, err := gzip.NewReader(file)
+			require.NoError(t, err)
+			reader = zr
+		} else {
+			assert.True(t, info.Size() <= 1000)
+		}
+		total += len(readJSONSpans(t, reader))
+		file.Close()
+	}
+	assert.Equal(t, 20, total)
+}
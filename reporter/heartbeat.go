@@ -0,0 +1,115 @@
+package reporter
+
+import (
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+const (
+	heartbeatMetric          = "~component.heartbeat"
+	defaultHeartbeatInterval = 5 * time.Minute
+)
+
+// defaultHeartbeatComponents are the components of the heartbeats sent for each service.
+var defaultHeartbeatComponents = []string{"go", "opentracing"}
+
+// heartbeater sends the heartbeat metric of an application at a configurable interval. It behaves like the
+// application.HeartbeatService of the Wavefront SDK, which always sends heartbeats every 5 minutes.
+type heartbeater struct {
+	sender     senders.Sender
+	app        application.Tags
+	source     string
+	components []string
+	stop       chan struct{}
+	done       chan struct{}
+
+	mtx        sync.Mutex // protects customTags
+	customTags []map[string]string
+}
+
+func startHeartbeater(sender senders.Sender, app application.Tags, source string, interval time.Duration,
+	components ...string) application.HeartbeatService {
+	hb := &heartbeater{
+		sender:     sender,
+		app:        app,
+		source:     source,
+		components: components,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	// the first beat is sent from the goroutine too, since the sender may block the caller reporting a span
+	go func() {
+		defer close(hb.done)
+		hb.beat()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				hb.beat()
+			case <-hb.stop:
+				return
+			}
+		}
+	}()
+	return hb
+}
+
+func (hb *heartbeater) beat() {
+	tags := hb.app.Map()
+	tags["component"] = "wavefront-generated"
+	hb.send(tags)
+	for _, component := range hb.components {
+		tags["component"] = component
+		hb.send(tags)
+	}
+
+	// the custom tags are sent once, until added again
+	hb.mtx.Lock()
+	customTags := hb.customTags
+	hb.customTags = nil
+	hb.mtx.Unlock()
+	for _, tags := range customTags {
+		hb.send(tags)
+	}
+}
+
+func (hb *heartbeater) send(tags map[string]string) {
+	if err := hb.sender.SendMetric(heartbeatMetric, 1, 0, hb.source, tags); err != nil {
+		log.Printf("heartbeater SendMetric error: %v\n", err)
+	}
+}
+
+// AddCustomTags complies with the application.HeartbeatService interface.
+func (hb *heartbeater) AddCustomTags(tags map[string]string) {
+	hb.mtx.Lock()
+	defer hb.mtx.Unlock()
+	for _, existing := range hb.customTags {
+		if reflect.DeepEqual(existing, tags) {
+			return
+		}
+	}
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	hb.customTags = append(hb.customTags, copied)
+}
+
+// Close complies with the application.HeartbeatService interface.
+func (hb *heartbeater) Close() {
+	close(hb.stop)
+	<-hb.done
+}
+
+// noopHeartbeater is used when heartbeats are disabled.
+type noopHeartbeater struct{}
+
+func (noopHeartbeater) AddCustomTags(tags map[string]string) {}
+
+func (noopHeartbeater) Close() {}
@@ -0,0 +1,132 @@
+package reporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/go-metrics-wavefront/reporting"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+func TestReporter_HeartbeatOptions(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"),
+		HeartbeatInterval(10*time.Millisecond), HeartbeatComponents("custom"))
+	defer r.Close()
+
+	assert.Eventually(t, func() bool { return sender.heartbeatCount() >= 6 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"app/service/none/none"}, sender.heartbeatServices("custom"))
+	assert.Equal(t, []string{"app/service/none/none"}, sender.heartbeatServices("wavefront-generated"))
+	assert.Empty(t, sender.heartbeatServices("opentracing"))
+}
+
+// blockingMetricSender is a testSender whose metric sends block until released.
+type blockingMetricSender struct {
+	testSender
+	release chan struct{}
+}
+
+func (s *blockingMetricSender) SendMetric(name string, value float64, ts int64, source string,
+	tags map[string]string) error {
+	<-s.release
+	return s.testSender.SendMetric(name, value, ts, source, tags)
+}
+
+func TestStartHeartbeater_DoesNotBlock(t *testing.T) {
+	sender := &blockingMetricSender{release: make(chan struct{})}
+	start := time.Now()
+	hb := startHeartbeater(sender, application.New("app", "service"), "host", time.Hour, "go")
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "the first beat is sent asynchronously")
+
+	close(sender.release)
+	assert.Eventually(t, func() bool { return sender.heartbeatCount() == 2 }, 5*time.Second, 10*time.Millisecond)
+	hb.Close()
+}
+
+func TestReporter_DisableHeartbeats(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"), DisableHeartbeats())
+	r.ReportSpan(spanWithTags("op", map[string]interface{}{"service": "other"}))
+	_, err := r.Shutdown(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, sender.heartbeatCount())
+	assert.Equal(t, 2, r.(*reporter).services.size(), "services are still tracked")
+}
+
+func TestReporter_InternalMetrics(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"), InternalMetricsInterval(10*time.Millisecond))
+	defer r.Close()
+	r.ReportSpan(newSpan("op"))
+
+	// sent without flushing
+	assert.Eventually(t, func() bool {
+		return len(sender.metricNames("∆~sdk.go.opentracing.reporter.spans.received")) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReporter_DisableInternalMetrics(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"), DisableInternalMetrics())
+	r.ReportSpan(newSpan("op"))
+	require.NoError(t, r.Flush(context.Background()))
+	_, err := r.Shutdown(context.Background())
+	require.NoError(t, err)
+
+	assert.Empty(t, sender.metricNames("~sdk"))
+	assert.Empty(t, sender.metricNames("∆~sdk"))
+	assert.NotEmpty(t, sender.metricNames("∆tracing.derived"), "the RED metrics are still sent")
+	assert.Equal(t, int64(1), r.(*reporter).spansReceived.Count(), "the internal metrics are still collected")
+}
+
+func TestReporter_InternalMetricsRegistry(t *testing.T) {
+	sender := &testSender{}
+	registry := metrics.NewRegistry()
+	r := New(sender, application.New("app", "service"), InternalMetricsRegistry(registry))
+	r.ReportSpan(newSpan("op"))
+	require.NoError(t, r.Flush(context.Background()))
+	_, err := r.Shutdown(context.Background())
+	require.NoError(t, err)
+
+	assert.Empty(t, sender.metricNames("∆~sdk"), "the owner of the registry exports the internal metrics")
+	received := registry.Get(reporting.EncodeKey("∆~sdk.go.opentracing.reporter.spans.received", nil))
+	require.NotNil(t, received)
+	assert.Equal(t, int64(1), received.(metrics.Counter).Count())
+	queueSize := registry.Get(reporting.EncodeKey("~sdk.go.opentracing.reporter.queue.size", nil))
+	require.NotNil(t, queueSize)
+	assert.Equal(t, int64(0), queueSize.(metrics.Gauge).Value())
+}
+
+func TestReporter_TracerMetrics(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"))
+	defer r.Close()
+	tr := tracer.New(r)
+	tr.StartSpan("op").Finish()
+	require.NoError(t, r.Flush(context.Background()))
+
+	assert.NotEmpty(t, sender.metricNames("∆~sdk.go.opentracing.tracer.spans.started"))
+	assert.NotEmpty(t, sender.metricNames("∆~sdk.go.opentracing.tracer.spans.finished"))
+}
+
+func TestReporter_TracerMetricsRegistry(t *testing.T) {
+	registry := metrics.NewRegistry()
+	r := New(&testSender{}, application.New("app", "service"), InternalMetricsRegistry(registry))
+	defer r.Close()
+	tr := tracer.New(r)
+	_, err := tr.Extract(opentracing.TextMap, "not a carrier")
+	require.Error(t, err)
+
+	key := reporting.EncodeKey("∆~sdk.go.opentracing.tracer.propagation.extract.errors",
+		map[string]string{"format": "text_map"})
+	errors := registry.Get(key)
+	require.NotNil(t, errors)
+	assert.Equal(t, int64(1), errors.(metrics.Counter).Count())
+}
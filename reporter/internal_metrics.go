@@ -0,0 +1,40 @@
+package reporter
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/go-metrics-wavefront/reporting"
+)
+
+// unreportedMetrics collects the internal metrics without reporting them, when they are disabled or exported
+// by the owner of the registry. Reporting would reset the delta counters.
+type unreportedMetrics struct {
+	registry metrics.Registry
+}
+
+var _ reporting.WavefrontMetricsReporter = unreportedMetrics{}
+
+func (m unreportedMetrics) Start() {}
+
+func (m unreportedMetrics) Close() {}
+
+func (m unreportedMetrics) Report() {}
+
+func (m unreportedMetrics) ErrorsCount() int64 {
+	return 0
+}
+
+func (m unreportedMetrics) RegisterMetric(name string, metric interface{}, tags map[string]string) error {
+	return m.registry.Register(reporting.EncodeKey(name, tags), metric)
+}
+
+func (m unreportedMetrics) GetMetric(name string, tags map[string]string) interface{} {
+	return m.registry.Get(reporting.EncodeKey(name, tags))
+}
+
+func (m unreportedMetrics) GetOrRegisterMetric(name string, i interface{}, tags map[string]string) interface{} {
+	return m.registry.GetOrRegister(reporting.EncodeKey(name, tags), i)
+}
+
+func (m unreportedMetrics) UnregisterMetric(name string, tags map[string]string) {
+	m.registry.Unregister(reporting.EncodeKey(name, tags))
+}
@@ -0,0 +1,423 @@
+package reporter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+const defaultMaxPacketSize = 65000
+
+// Thrift compact protocol types.
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftStruct    = 12
+)
+
+// Jaeger tag types and span reference types, see jaeger-idl/thrift/jaeger.thrift.
+const (
+	jaegerTagString = 0
+	jaegerTagDouble = 1
+	jaegerTagBool   = 2
+	jaegerTagLong   = 3
+
+	jaegerChildOf     = 0
+	jaegerFollowsFrom = 1
+
+	jaegerFlagSampled = 1
+	jaegerFlagDebug   = 2
+)
+
+type jaegerReporter struct {
+	*batcher
+	conn          net.Conn
+	application   application.Tags
+	maxPacketSize int
+}
+
+// NewJaegerAgentSpanReporter returns a SpanReporter exporting spans to the Jaeger agent at the given UDP address,
+// for example "localhost:6831", using the Thrift compact protocol. Batches are split to fit in the maximum
+// packet size, and spans are grouped into processes by their "service" tag.
+func NewJaegerAgentSpanReporter(address string, app application.Tags, options ...ExporterOption) (tracer.SpanReporter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	cfg := newExporterConfig(options)
+	r := &jaegerReporter{
+		conn:          conn,
+		application:   app,
+		maxPacketSize: cfg.maxPacketSize,
+	}
+	r.batcher = newBatcher("jaeger reporter", cfg, r.export)
+	r.batcher.closer = conn.Close
+	return r, nil
+}
+
+func (r *jaegerReporter) export(spans []tracer.RawSpan) error {
+	byService := make(map[string][][]byte)
+	for _, span := range spans {
+		encoded, err := jaegerSpan(span, r.cfg.encoder)
+		if err != nil {
+			logSkippedSpan(span, err)
+			continue
+		}
+		service, _ := getAppTag("service", r.application.Service, span.Tags, r.cfg.encoder)
+		byService[service] = append(byService[service], encoded)
+	}
+
+	services := make([]string, 0, len(byService))
+	for service := range byService {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	for _, service := range services {
+		if err := r.emit(r.process(service), byService[service]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit sends the encoded spans in as few emitBatch packets as the maximum packet size allows.
+func (r *jaegerReporter) emit(process []byte, spans [][]byte) error {
+	// the envelope, the process and the headers of an empty batch, the list header growing with 15 spans or more
+	overhead := len(jaegerBatch(process, nil))
+	for len(spans) > 0 {
+		size, n := overhead, 0
+		for n < len(spans) && size+len(spans[n])+listSizeBytes(n+1) <= r.maxPacketSize {
+			size += len(spans[n])
+			n++
+		}
+		if n == 0 {
+			log.Printf("jaeger reporter skipping span of %d bytes exceeding the maximum packet size", len(spans[0]))
+			spans = spans[1:]
+			continue
+		}
+		if _, err := r.conn.Write(jaegerBatch(process, spans[:n])); err != nil {
+			return err
+		}
+		spans = spans[n:]
+	}
+	return nil
+}
+
+func (r *jaegerReporter) process(service string) []byte {
+	var tags []jaegerTag
+	for k, v := range r.application.Map() {
+		if k != "service" && v != "" {
+			tags = append(tags, jaegerTag{k, v})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].key < tags[j].key })
+
+	var b thriftBuffer
+	b.binaryField(1, []byte(service))
+	if len(tags) > 0 {
+		b.tagList(2, tags)
+	}
+	b.stop()
+	return b.buf
+}
+
+// jaegerEnvelope returns the header of a oneway Agent.emitBatch message.
+func jaegerEnvelope() []byte {
+	var b thriftBuffer
+	b.buf = append(b.buf, 0x82, 1|4<<5)
+	b.uvarint(0)
+	b.binary([]byte("emitBatch"))
+	return b.buf
+}
+
+func jaegerBatch(process []byte, spans [][]byte) []byte {
+	b := thriftBuffer{buf: jaegerEnvelope()}
+	b.structField(1, func(batch *thriftBuffer) {
+		batch.structField(1, func(p *thriftBuffer) {
+			p.buf = append(p.buf, process...)
+		})
+		batch.fieldHeader(2, thriftList)
+		batch.listHeader(thriftStruct, len(spans))
+		for _, span := range spans {
+			batch.buf = append(batch.buf, span...)
+		}
+		batch.stop()
+	})
+	b.stop()
+	return b.buf
+}
+
+func jaegerSpan(span tracer.RawSpan, encode ValueEncoder) ([]byte, error) {
+	traceHigh, traceLow, err := jaegerTraceID(span.Context.TraceID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace id: %v", err)
+	}
+	spanID, err := jaegerSpanID(span.Context.SpanID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid span id: %v", err)
+	}
+
+	var parentID int64
+	var refs [][]byte
+	for _, ref := range span.References {
+		refCtx, ok := ref.ReferencedContext.(tracer.SpanContext)
+		if !ok {
+			continue
+		}
+		refHigh, refLow, err := jaegerTraceID(refCtx.TraceID)
+		if err != nil {
+			continue
+		}
+		refSpanID, err := jaegerSpanID(refCtx.SpanID)
+		if err != nil {
+			continue
+		}
+		refType := int64(jaegerChildOf)
+		if ref.Type == opentracing.FollowsFromRef {
+			refType = jaegerFollowsFrom
+		} else if parentID == 0 {
+			parentID = refSpanID
+		}
+		var rb thriftBuffer
+		rb.i32Field(1, refType)
+		rb.i64Field(2, refLow)
+		rb.i64Field(3, refHigh)
+		rb.i64Field(4, refSpanID)
+		rb.stop()
+		refs = append(refs, rb.buf)
+	}
+
+	var flags int64
+	if !span.Context.IsSampled() || *span.Context.SamplingDecision() {
+		flags |= jaegerFlagSampled
+	}
+	if hasTrueTag("debug", span.Tags) {
+		flags |= jaegerFlagDebug
+	}
+
+	var b thriftBuffer
+	b.i64Field(1, traceLow)
+	b.i64Field(2, traceHigh)
+	b.i64Field(3, spanID)
+	b.i64Field(4, parentID)
+	b.binaryField(5, []byte(span.Operation))
+	if len(refs) > 0 {
+		b.fieldHeader(6, thriftList)
+		b.listHeader(thriftStruct, len(refs))
+		for _, ref := range refs {
+			b.buf = append(b.buf, ref...)
+		}
+	}
+	b.i32Field(7, flags)
+	b.i64Field(8, span.Start.UnixNano()/1000)
+	b.i64Field(9, span.Duration.Nanoseconds()/1000)
+
+	tags := make([]jaegerTag, 0, len(span.Tags)+1)
+	for k, v := range span.Tags {
+		tags = append(tags, jaegerTag{k, typedValue(v, encode)})
+		for _, additional := range span.AdditionalTags[k] {
+			tags = append(tags, jaegerTag{k, typedValue(additional, encode)})
+		}
+	}
+	if _, found := span.Tags["component"]; !found && span.Component != "" {
+		tags = append(tags, jaegerTag{"component", span.Component})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].key < tags[j].key })
+	if len(tags) > 0 {
+		b.tagList(10, tags)
+	}
+
+	if len(span.Logs) > 0 {
+		b.fieldHeader(11, thriftList)
+		b.listHeader(thriftStruct, len(span.Logs))
+		for _, lr := range span.Logs {
+			fields := make([]jaegerTag, len(lr.Fields))
+			for i, field := range lr.Fields {
+				fields[i] = jaegerTag{field.Key(), typedValue(field.Value(), encode)}
+			}
+			// the fields of a log are required, even when empty
+			var lb thriftBuffer
+			lb.i64Field(1, lr.Timestamp.UnixNano()/1000)
+			lb.tagList(2, fields)
+			lb.stop()
+			b.buf = append(b.buf, lb.buf...)
+		}
+	}
+	b.stop()
+	return b.buf, nil
+}
+
+func jaegerTraceID(id string) (high, low int64, err error) {
+	b, err := idBytes(id, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:8])), int64(binary.BigEndian.Uint64(b[8:])), nil
+}
+
+func jaegerSpanID(id string) (int64, error) {
+	b, err := idBytes(id, 8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+type jaegerTag struct {
+	key   string
+	value interface{}
+}
+
+// thriftBuffer encodes structs with the Thrift compact protocol.
+type thriftBuffer struct {
+	buf       []byte
+	lastField int
+}
+
+func (b *thriftBuffer) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	b.buf = append(b.buf, tmp[:n]...)
+}
+
+func (b *thriftBuffer) zigzag(v int64) {
+	b.uvarint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (b *thriftBuffer) binary(v []byte) {
+	b.uvarint(uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *thriftBuffer) fieldHeader(field, fieldType int) {
+	if delta := field - b.lastField; delta > 0 && delta <= 15 {
+		b.buf = append(b.buf, byte(delta<<4|fieldType))
+	} else {
+		b.buf = append(b.buf, byte(fieldType))
+		b.zigzag(int64(field))
+	}
+	b.lastField = field
+}
+
+func (b *thriftBuffer) listHeader(elemType, size int) {
+	if size < 15 {
+		b.buf = append(b.buf, byte(size<<4|elemType))
+	} else {
+		b.buf = append(b.buf, byte(0xf0|elemType))
+		b.uvarint(uint64(size))
+	}
+}
+
+// listSizeBytes returns the number of bytes taken by the size of a list in its header, besides the header byte.
+func listSizeBytes(size int) int {
+	if size < 15 {
+		return 0
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(tmp[:], uint64(size))
+}
+
+func (b *thriftBuffer) stop() {
+	b.buf = append(b.buf, 0)
+}
+
+func (b *thriftBuffer) i32Field(field int, v int64) {
+	b.fieldHeader(field, thriftI32)
+	b.zigzag(v)
+}
+
+func (b *thriftBuffer) i64Field(field int, v int64) {
+	b.fieldHeader(field, thriftI64)
+	b.zigzag(v)
+}
+
+func (b *thriftBuffer) binaryField(field int, v []byte) {
+	b.fieldHeader(field, thriftBinary)
+	b.binary(v)
+}
+
+func (b *thriftBuffer) boolField(field int, v bool) {
+	if v {
+		b.fieldHeader(field, thriftBoolTrue)
+	} else {
+		b.fieldHeader(field, thriftBoolFalse)
+	}
+}
+
+func (b *thriftBuffer) doubleField(field int, v float64) {
+	b.fieldHeader(field, thriftDouble)
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+	b.buf = append(b.buf, tmp[:]...)
+}
+
+func (b *thriftBuffer) structField(field int, encode func(b *thriftBuffer)) {
+	b.fieldHeader(field, thriftStruct)
+	nested := thriftBuffer{buf: b.buf}
+	encode(&nested)
+	b.buf = nested.buf
+}
+
+// tagList writes a list of Jaeger tags, keeping the type of the values where Jaeger supports it.
+func (b *thriftBuffer) tagList(field int, tags []jaegerTag) {
+	b.fieldHeader(field, thriftList)
+	b.listHeader(thriftStruct, len(tags))
+	for _, tag := range tags {
+		var tb thriftBuffer
+		tb.binaryField(1, []byte(tag.key))
+		switch v := tag.value.(type) {
+		case bool:
+			tb.i32Field(2, jaegerTagBool)
+			tb.boolField(5, v)
+		case int, int8, int16, int32, int64, uint8, uint16, uint32:
+			tb.i32Field(2, jaegerTagLong)
+			tb.i64Field(6, toInt64(v))
+		case float32:
+			tb.i32Field(2, jaegerTagDouble)
+			tb.doubleField(4, float64(v))
+		case float64:
+			tb.i32Field(2, jaegerTagDouble)
+			tb.doubleField(4, v)
+		default:
+			tb.i32Field(2, jaegerTagString)
+			tb.binaryField(3, []byte(fmt.Sprint(v)))
+		}
+		tb.stop()
+		b.buf = append(b.buf, tb.buf...)
+	}
+}
+
+func toInt64(v interface{}) int64 {
+	switch i := v.(type) {
+	case int:
+		return int64(i)
+	case int8:
+		return int64(i)
+	case int16:
+		return int64(i)
+	case int32:
+		return int64(i)
+	case int64:
+		return i
+	case uint8:
+		return int64(i)
+	case uint16:
+		return int64(i)
+	case uint32:
+		return int64(i)
+	}
+	return 0
+}
@@ -0,0 +1,162 @@
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+func listenUDP(t *testing.T) (*net.UDPConn, func() [][]byte) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	return conn, func() [][]byte {
+		var packets [][]byte
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return packets
+			}
+			packets = append(packets, append([]byte(nil), buf[:n]...))
+		}
+	}
+}
+
+// jaegerSpanCount returns the number of spans in an emitBatch packet for the given encoded process.
+func jaegerSpanCount(t *testing.T, packet, process []byte) int {
+	envelope := jaegerEnvelope()
+	require.True(t, bytes.HasPrefix(packet, envelope))
+	// batch and process field headers
+	offset := len(envelope) + 2
+	require.True(t, bytes.HasPrefix(packet[offset:], process))
+	offset += len(process) + 1 // spans field header
+	header := packet[offset]
+	require.Equal(t, byte(thriftStruct), header&0x0f)
+	return int(header >> 4)
+}
+
+func TestJaegerAgentSpanReporter(t *testing.T) {
+	agent, read := listenUDP(t)
+	defer agent.Close()
+
+	app := application.New("app", "svc")
+	r, err := NewJaegerAgentSpanReporter(agent.LocalAddr().String(), app)
+	require.NoError(t, err)
+
+	parent := newSpan("parent")
+	child := newSpan("child")
+	child.Context.TraceID = parent.Context.TraceID
+	child.References = []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: parent.Context}}
+	child.Tags = opentracing.Tags{"service": "other", "http.status_code": 200}
+
+	r.ReportSpan(parent)
+	r.ReportSpan(child)
+	require.NoError(t, r.(*jaegerReporter).Flush(context.Background()))
+	require.NoError(t, r.Close())
+
+	packets := read()
+	require.Len(t, packets, 2)
+	jr := r.(*jaegerReporter)
+	assert.Equal(t, 1, jaegerSpanCount(t, packets[0], jr.process("other")))
+	assert.Equal(t, 1, jaegerSpanCount(t, packets[1], jr.process("svc")))
+	assert.Contains(t, string(packets[0]), "child")
+	assert.Contains(t, string(packets[0]), "http.status_code")
+	assert.Contains(t, string(packets[1]), "parent")
+
+	encoded, err := jaegerSpan(child, DefaultValueEncoder)
+	require.NoError(t, err)
+	parentID, _ := jaegerSpanID(parent.Context.SpanID)
+	var want thriftBuffer
+	want.lastField = 3
+	want.i64Field(4, parentID)
+	assert.Contains(t, string(encoded), string(want.buf), "parent span id")
+}
+
+func TestJaegerAgentSpanReporter_SplitsBatches(t *testing.T) {
+	agent, read := listenUDP(t)
+	defer agent.Close()
+
+	app := application.New("app", "svc")
+	r, err := NewJaegerAgentSpanReporter(agent.LocalAddr().String(), app, ExporterMaxPacketSize(400))
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		r.ReportSpan(newSpan("operation"))
+	}
+	require.NoError(t, r.(*jaegerReporter).Flush(context.Background()))
+	require.NoError(t, r.Close())
+
+	packets := read()
+	require.True(t, len(packets) > 1)
+	process := r.(*jaegerReporter).process("svc")
+	total := 0
+	for _, packet := range packets {
+		assert.True(t, len(packet) <= 400, "packet of %d bytes", len(packet))
+		total += jaegerSpanCount(t, packet, process)
+	}
+	assert.Equal(t, 20, total)
+}
+
+func TestJaegerAgentSpanReporter_FullPacket(t *testing.T) {
+	agent, read := listenUDP(t)
+	defer agent.Close()
+
+	app := application.New("app", "svc")
+	var spans []tracer.RawSpan
+	var encoded [][]byte
+	for i := 0; i < 20; i++ {
+		span := newSpan("operation")
+		spans = append(spans, span)
+		data, err := jaegerSpan(span, DefaultValueEncoder)
+		require.NoError(t, err)
+		encoded = append(encoded, data)
+	}
+	process := (&jaegerReporter{application: app}).process("svc")
+	size := len(jaegerBatch(process, encoded))
+
+	r, err := NewJaegerAgentSpanReporter(agent.LocalAddr().String(), app, ExporterMaxPacketSize(size))
+	require.NoError(t, err)
+	for _, span := range spans {
+		r.ReportSpan(span)
+	}
+	require.NoError(t, r.(*jaegerReporter).Flush(context.Background()))
+	require.NoError(t, r.Close())
+
+	packets := read()
+	require.Len(t, packets, 1, "the spans fit in a packet of the maximum size")
+	assert.Len(t, packets[0], size)
+}
+
+func TestJaegerSpan_EmptyLog(t *testing.T) {
+	span := newSpan("op")
+	timestamp := time.Now()
+	span.Logs = []opentracing.LogRecord{{Timestamp: timestamp}}
+	encoded, err := jaegerSpan(span, DefaultValueEncoder)
+	require.NoError(t, err)
+
+	var want thriftBuffer
+	want.i64Field(1, timestamp.UnixNano()/1000)
+	want.fieldHeader(2, thriftList)
+	want.listHeader(thriftStruct, 0)
+	want.stop()
+	assert.Contains(t, string(encoded), string(want.buf), "the required fields of the log are written")
+}
+
+func TestThriftBuffer(t *testing.T) {
+	var b thriftBuffer
+	b.i32Field(1, -1)
+	b.i64Field(20, 300)
+	b.boolField(21, true)
+	b.listHeader(thriftStruct, 20)
+	b.stop()
+	assert.Equal(t, []byte{0x15, 0x01, 0x06, 0x28, 0xd8, 0x04, 0x11, 0xfc, 0x14, 0x00}, b.buf)
+}
@@ -0,0 +1,287 @@
+package reporter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+const (
+	otlpContentType = "application/x-protobuf"
+	otlpScopeName   = "github.com/wavefronthq/wavefront-opentracing-sdk-go"
+)
+
+// OTLP span kinds and status codes, see opentelemetry/proto/trace/v1/trace.proto.
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpKindClient   = 3
+	otlpKindProducer = 4
+	otlpKindConsumer = 5
+
+	otlpStatusError = 2
+)
+
+type otlpReporter struct {
+	*batcher
+	endpoint string
+	resource []byte
+}
+
+// NewOTLPSpanReporter returns a SpanReporter exporting spans in batches to an OpenTelemetry collector
+// using OTLP/HTTP with protobuf encoding, for example to "http://localhost:4318/v1/traces".
+// The application tags are reported as resource attributes, with the service as "service.name".
+func NewOTLPSpanReporter(endpoint string, app application.Tags, options ...ExporterOption) tracer.SpanReporter {
+	r := &otlpReporter{
+		endpoint: endpoint,
+		resource: otlpResource(app),
+	}
+	r.batcher = newBatcher("otlp reporter", newExporterConfig(options), r.export)
+	return r
+}
+
+func (r *otlpReporter) export(spans []tracer.RawSpan) error {
+	return r.batcher.cfg.post(r.endpoint, otlpContentType, r.encode(spans))
+}
+
+// encode returns the ExportTraceServiceRequest message for the spans.
+func (r *otlpReporter) encode(spans []tracer.RawSpan) []byte {
+	var scope protoBuffer
+	scope.message(1, func(b *protoBuffer) {
+		b.string(1, otlpScopeName)
+	})
+	for _, span := range spans {
+		if msg, err := otlpSpan(span, r.cfg.encoder); err != nil {
+			logSkippedSpan(span, err)
+		} else {
+			scope.bytes(2, msg)
+		}
+	}
+
+	var resourceSpans protoBuffer
+	resourceSpans.bytes(1, r.resource)
+	resourceSpans.bytes(2, scope.buf)
+
+	var req protoBuffer
+	req.bytes(1, resourceSpans.buf)
+	return req.buf
+}
+
+func otlpResource(app application.Tags) []byte {
+	var b protoBuffer
+	b.message(1, func(kv *protoBuffer) { otlpKeyValue(kv, "service.name", app.Service) })
+	for k, v := range app.Map() {
+		if v != "" {
+			b.message(1, func(kv *protoBuffer) { otlpKeyValue(kv, k, v) })
+		}
+	}
+	return b.buf
+}
+
+func otlpSpan(span tracer.RawSpan, encode ValueEncoder) ([]byte, error) {
+	traceID, err := idBytes(span.Context.TraceID, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace id: %v", err)
+	}
+	spanID, err := idBytes(span.Context.SpanID, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid span id: %v", err)
+	}
+
+	var b protoBuffer
+	b.bytes(1, traceID)
+	b.bytes(2, spanID)
+
+	// the first ChildOf reference is the parent, the other ones are reported as links
+	parent := parentIndex(span)
+	for i, ref := range span.References {
+		refCtx, ok := ref.ReferencedContext.(tracer.SpanContext)
+		if !ok {
+			continue
+		}
+		refSpanID, err := idBytes(refCtx.SpanID, 8)
+		if err != nil {
+			continue
+		}
+		if i == parent {
+			b.bytes(4, refSpanID)
+			continue
+		}
+		refTraceID, err := idBytes(refCtx.TraceID, 16)
+		if err != nil {
+			continue
+		}
+		b.message(13, func(link *protoBuffer) {
+			link.bytes(1, refTraceID)
+			link.bytes(2, refSpanID)
+			link.message(4, func(kv *protoBuffer) { otlpKeyValue(kv, "opentracing.ref_type", refType(ref.Type)) })
+		})
+	}
+
+	b.string(5, span.Operation)
+	b.varint(6, otlpKind(span.Tags))
+	b.fixed64(7, uint64(span.Start.UnixNano()))
+	b.fixed64(8, uint64(span.Start.Add(span.Duration).UnixNano()))
+
+	for k, v := range span.Tags {
+		if k == string(ext.SpanKind) || k == string(ext.Error) {
+			continue
+		}
+		value := typedValue(v, encode)
+		if additional := span.AdditionalTags[k]; len(additional) > 0 {
+			// the tags with several values are reported as arrays
+			values := []interface{}{value}
+			for _, a := range additional {
+				values = append(values, typedValue(a, encode))
+			}
+			value = values
+		}
+		b.message(9, func(kv *protoBuffer) { otlpKeyValue(kv, k, value) })
+	}
+	if _, found := span.Tags["component"]; !found && span.Component != "" {
+		b.message(9, func(kv *protoBuffer) { otlpKeyValue(kv, "component", span.Component) })
+	}
+
+	for _, lr := range span.Logs {
+		b.message(11, func(event *protoBuffer) {
+			event.fixed64(1, uint64(lr.Timestamp.UnixNano()))
+			name := "log"
+			for _, field := range lr.Fields {
+				if field.Key() == "event" {
+					name = encode(field.Value())
+				}
+			}
+			event.string(2, name)
+			for _, field := range lr.Fields {
+				event.message(3, func(kv *protoBuffer) { otlpKeyValue(kv, field.Key(), typedValue(field.Value(), encode)) })
+			}
+		})
+	}
+
+	if hasTrueTag(string(ext.Error), span.Tags) {
+		b.message(15, func(status *protoBuffer) {
+			status.varint(3, otlpStatusError)
+		})
+	}
+	return b.buf, nil
+}
+
+func otlpKind(tags opentracing.Tags) uint64 {
+	kind, _ := getAppTag(string(ext.SpanKind), "", tags, DefaultValueEncoder)
+	switch kind {
+	case string(ext.SpanKindRPCServerEnum):
+		return otlpKindServer
+	case string(ext.SpanKindRPCClientEnum):
+		return otlpKindClient
+	case string(ext.SpanKindProducerEnum):
+		return otlpKindProducer
+	case string(ext.SpanKindConsumerEnum):
+		return otlpKindConsumer
+	}
+	return otlpKindInternal
+}
+
+// otlpKeyValue writes a KeyValue message, keeping the type of the value where OTLP supports it.
+func otlpKeyValue(b *protoBuffer, key string, value interface{}) {
+	b.string(1, key)
+	b.message(2, func(av *protoBuffer) { otlpAnyValue(av, value) })
+}
+
+// otlpAnyValue writes the fields of an AnyValue message. Slices of values are written as arrays.
+func otlpAnyValue(av *protoBuffer, value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		av.message(5, func(array *protoBuffer) {
+			for _, item := range v {
+				array.message(1, func(itemValue *protoBuffer) { otlpAnyValue(itemValue, item) })
+			}
+		})
+	case string:
+		av.string(1, v)
+	case bool:
+		var i uint64
+		if v {
+			i = 1
+		}
+		av.varint(2, i)
+	case int:
+		av.varint(3, uint64(v))
+	case int8:
+		av.varint(3, uint64(v))
+	case int16:
+		av.varint(3, uint64(v))
+	case int32:
+		av.varint(3, uint64(v))
+	case int64:
+		av.varint(3, uint64(v))
+	case uint8:
+		av.varint(3, uint64(v))
+	case uint16:
+		av.varint(3, uint64(v))
+	case uint32:
+		av.varint(3, uint64(v))
+	case float32:
+		av.fixed64(4, math.Float64bits(float64(v)))
+	case float64:
+		av.fixed64(4, math.Float64bits(v))
+	default:
+		av.string(1, fmt.Sprint(v))
+	}
+}
+
+func refType(refType opentracing.SpanReferenceType) string {
+	if refType == opentracing.FollowsFromRef {
+		return "follows_from"
+	}
+	return "child_of"
+}
+
+// protoBuffer encodes protocol buffer messages field by field.
+type protoBuffer struct {
+	buf []byte
+}
+
+func (b *protoBuffer) tag(field, wireType int) {
+	b.uvarint(uint64(field<<3 | wireType))
+}
+
+func (b *protoBuffer) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	b.buf = append(b.buf, tmp[:n]...)
+}
+
+func (b *protoBuffer) varint(field int, v uint64) {
+	b.tag(field, 0)
+	b.uvarint(v)
+}
+
+func (b *protoBuffer) fixed64(field int, v uint64) {
+	b.tag(field, 1)
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	b.buf = append(b.buf, tmp[:]...)
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.tag(field, 2)
+	b.uvarint(uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *protoBuffer) string(field int, v string) {
+	b.tag(field, 2)
+	b.uvarint(uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *protoBuffer) message(field int, encode func(b *protoBuffer)) {
+	var msg protoBuffer
+	encode(&msg)
+	b.bytes(field, msg.buf)
+}
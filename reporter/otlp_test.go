@@ -0,0 +1,243 @@
+package reporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	stdlog "log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+// protoFields decodes the fields of a protocol buffer message, keyed by field number.
+// Varint and fixed64 values are returned as 8 byte little endian slices.
+func protoFields(t *testing.T, msg []byte) map[int][][]byte {
+	fields := make(map[int][][]byte)
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		require.True(t, n > 0)
+		msg = msg[n:]
+		var value []byte
+		switch tag & 7 {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			require.True(t, n > 0)
+			value = make([]byte, 8)
+			binary.LittleEndian.PutUint64(value, v)
+			msg = msg[n:]
+		case 1:
+			value, msg = msg[:8], msg[8:]
+		case 2:
+			l, n := binary.Uvarint(msg)
+			require.True(t, n > 0)
+			value, msg = msg[n:n+int(l)], msg[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields[int(tag>>3)] = append(fields[int(tag>>3)], value)
+	}
+	return fields
+}
+
+func protoAttributes(t *testing.T, kvs [][]byte) map[string][]byte {
+	attrs := make(map[string][]byte)
+	for _, kv := range kvs {
+		fields := protoFields(t, kv)
+		attrs[string(fields[1][0])] = fields[2][0]
+	}
+	return attrs
+}
+
+type otlpCollector struct {
+	sync.Mutex
+	requests [][]byte
+	status   int
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+		c.status = 0
+		return
+	}
+	body := r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		body, _ = gzip.NewReader(r.Body)
+	}
+	data, _ := ioutil.ReadAll(body)
+	c.requests = append(c.requests, data)
+}
+
+func TestOTLPSpanReporter(t *testing.T) {
+	collector := &otlpCollector{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	r := NewOTLPSpanReporter(server.URL+"/v1/traces", application.New("app", "svc"), ExporterGzip(), ExporterRetry(policy))
+
+	parent := newSpan("parent")
+	child := newSpan("child")
+	child.Context.TraceID = parent.Context.TraceID
+	child.References = []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: parent.Context}}
+	child.Tags = opentracing.Tags{"span.kind": "server", "error": true, "http.status_code": 500, "ratio": 0.5, "db.table": "users"}
+	child.AdditionalTags = map[string][]interface{}{"db.table": {"orders"}}
+	child.Logs = []opentracing.LogRecord{{Timestamp: time.Now(), Fields: []log.Field{log.String("event", "retry"), log.Int("attempt", 2)}}}
+	r.ReportSpan(parent)
+	r.ReportSpan(child)
+
+	require.NoError(t, r.(*otlpReporter).Flush(context.Background()))
+	require.NoError(t, r.Close())
+	require.Len(t, collector.requests, 1)
+
+	resourceSpans := protoFields(t, protoFields(t, collector.requests[0])[1][0])
+	resource := protoAttributes(t, protoFields(t, resourceSpans[1][0])[1])
+	assert.Equal(t, "svc", string(protoFields(t, resource["service.name"])[1][0]))
+	assert.Equal(t, "app", string(protoFields(t, resource["application"])[1][0]))
+
+	spans := protoFields(t, resourceSpans[2][0])[2]
+	require.Len(t, spans, 2)
+	span := protoFields(t, spans[1])
+	assert.Equal(t, "child", string(span[5][0]))
+	assert.Equal(t, uint64(otlpKindServer), binary.LittleEndian.Uint64(span[6][0]))
+	parentID, _ := idBytes(parent.Context.SpanID, 8)
+	assert.Equal(t, parentID, span[4][0])
+	traceID, _ := idBytes(parent.Context.TraceID, 16)
+	assert.Equal(t, traceID, span[1][0])
+
+	attrs := protoAttributes(t, span[9])
+	assert.NotContains(t, attrs, "span.kind")
+	assert.NotContains(t, attrs, "error")
+	assert.Equal(t, uint64(500), binary.LittleEndian.Uint64(protoFields(t, attrs["http.status_code"])[3][0]))
+	assert.Equal(t, 0.5, math.Float64frombits(binary.LittleEndian.Uint64(protoFields(t, attrs["ratio"])[4][0])))
+	tables := protoFields(t, protoFields(t, attrs["db.table"])[5][0])[1]
+	require.Len(t, tables, 2)
+	assert.Equal(t, "users", string(protoFields(t, tables[0])[1][0]))
+	assert.Equal(t, "orders", string(protoFields(t, tables[1])[1][0]))
+
+	status := protoFields(t, span[15][0])
+	assert.Equal(t, uint64(otlpStatusError), binary.LittleEndian.Uint64(status[3][0]))
+
+	event := protoFields(t, span[11][0])
+	assert.Equal(t, "retry", string(event[2][0]))
+	assert.Len(t, event[3], 2)
+}
+
+func TestOTLPSpan_References(t *testing.T) {
+	previous, parent := newSpan("previous"), newSpan("parent")
+	span := newSpan("span")
+	span.References = []opentracing.SpanReference{
+		{Type: opentracing.FollowsFromRef, ReferencedContext: previous.Context},
+		{Type: opentracing.ChildOfRef, ReferencedContext: parent.Context},
+	}
+	msg, err := otlpSpan(span, DefaultValueEncoder)
+	require.NoError(t, err)
+
+	fields := protoFields(t, msg)
+	parentID, _ := idBytes(parent.Context.SpanID, 8)
+	assert.Equal(t, [][]byte{parentID}, fields[4], "the ChildOf reference is the parent")
+	require.Len(t, fields[13], 1)
+	link := protoFields(t, fields[13][0])
+	previousID, _ := idBytes(previous.Context.SpanID, 8)
+	assert.Equal(t, previousID, link[2][0])
+	assert.Equal(t, "follows_from", string(protoFields(t, protoAttributes(t, link[4])["opentracing.ref_type"])[1][0]))
+}
+
+func TestBatcher_LogDropped(t *testing.T) {
+	var out bytes.Buffer
+	stdlog.SetOutput(&out)
+	defer stdlog.SetOutput(os.Stderr)
+
+	b := &batcher{name: "otlp reporter"}
+	for i := 0; i < 3; i++ {
+		b.logDropped()
+	}
+	assert.Equal(t, 1, strings.Count(out.String(), "\n"), "the drops are logged once per interval")
+	assert.Contains(t, out.String(), "otlp reporter buffer full, dropped 1 spans")
+
+	b.lastDropLog = b.lastDropLog.Add(-dropLogInterval)
+	b.logDropped()
+	assert.Contains(t, out.String(), "otlp reporter buffer full, dropped 3 spans")
+}
+
+func TestBatcher_ShutdownBlockedExport(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	exporting := make(chan struct{}, 1)
+	b := newBatcher("test reporter", newExporterConfig([]ExporterOption{ExporterBatchSize(1)}),
+		func(spans []tracer.RawSpan) error {
+			exporting <- struct{}{}
+			<-release
+			return nil
+		})
+	closed := make(chan struct{})
+	b.closer = func() error {
+		close(closed)
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		b.ReportSpan(newSpan("span"))
+	}
+	<-exporting
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	lost, err := b.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "the blocked export is not waited for")
+	assert.Equal(t, 2, lost, "the queued spans are lost")
+
+	select {
+	case <-closed:
+		t.Fatal("the exporter is closed while exporting")
+	default:
+	}
+	release <- struct{}{}
+	<-closed
+}
+
+func TestOTLPSpanReporter_Unsampled(t *testing.T) {
+	collector := &otlpCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	r := NewOTLPSpanReporter(server.URL, application.New("app", "svc"))
+	span := newSpan("unsampled")
+	decision := false
+	span.Context.Sampled = &decision
+	r.ReportSpan(span)
+	require.NoError(t, r.Close())
+	assert.Empty(t, collector.requests)
+}
+
+func TestOTLPSpanReporter_ExportFailure(t *testing.T) {
+	collector := &otlpCollector{status: http.StatusBadRequest}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	r := NewOTLPSpanReporter(server.URL, application.New("app", "svc"), ExporterRetry(DefaultRetryPolicy()))
+	r.ReportSpan(newSpan("rejected"))
+	err := r.(*otlpReporter).Flush(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400")
+	require.NoError(t, r.Close())
+}
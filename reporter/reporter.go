@@ -2,12 +2,15 @@
 package reporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/opentracing/opentracing-go/ext"
@@ -21,21 +24,84 @@ import (
 // WavefrontSpanReporter implements the wavefront.Reporter interface.
 type WavefrontSpanReporter interface {
 	tracer.SpanReporter
-	Flush()
+
+	// Flush sends the spans reported before the call, then flushes the metrics and the sender.
+	// It returns an error if any of those spans could not be sent or the sender failed to flush.
+	Flush(ctx context.Context) error
+
+	// Shutdown stops accepting spans, sends the queued spans and flushes the metrics and the sender.
+	// Spans still queued or failing to send when the context is done are lost, unless a spill queue is
+	// configured. Once the context is done, a blocked send is not waited for, and neither the metrics nor the
+	// sender are flushed. It returns the number of lost spans, and the context error if the context was done first.
+	Shutdown(ctx context.Context) (int, error)
+
+	// Status returns a snapshot of the state of the reporter. See NewStatusHandler and PublishExpvar.
+	Status() Status
+
+	// Healthy reports whether the reporter is open and sending spans has not failed for too long.
+	// See UnhealthyAfter.
+	Healthy() bool
+
+	// TracerDeltaCounter and TracerGauge register the internal metrics of the tracer, sent like the internal metrics of the
+	// reporter. The tracer uses it when created with this reporter, or with tracer.WithMetricsRegistry.
+	tracer.MetricsRegistry
 }
 
+var (
+	// ErrReporterClosed is returned when flushing or shutting down a reporter that was already shut down.
+	ErrReporterClosed = errors.New("wavefront reporter already closed")
+
+	errShutdownTimeout = errors.New("span discarded after shutdown deadline")
+)
+
 type reporter struct {
-	source           string
-	sender           senders.Sender
-	application      application.Tags
-	heartbeater      application.HeartbeatService
+	source      string
+	sender      senders.Sender
+	application application.Tags
+	heartbeater application.HeartbeatService
+	services    *serviceRegistry
+	maxServices int
+
+	heartbeatsDisabled      bool
+	heartbeatInterval       time.Duration
+	heartbeatComponents     []string
+	internalMetricsDisabled bool
+	internalMetricsInterval time.Duration
+	internalRegistry        metrics.Registry
+
+	unhealthyAfter   time.Duration
+	encoder          ValueEncoder
+	healthMtx        sync.Mutex // protects the fields below
+	lastError        error
+	lastErrorTime    time.Time
+	lastSuccessTime  time.Time
+	failingSince     time.Time
 	bufferSize       int
 	spansCh          chan tracer.RawSpan
-	done             chan bool
+	done             chan struct{}
+	flushReqs        chan chan error
+	failuresMtx      sync.Mutex
+	failures         int // spans that failed to send since the last flush
+	lastFailure      error
+	intake           sync.RWMutex // guards closing spansCh
+	closed           int32
+	lost             int64
 	logPercent       float32
 	mtx              sync.Mutex
+	queueMtx         sync.Mutex
+	backpressure     BackpressurePolicy
+	blockTimeout     time.Duration
+	spillDir         string
+	spillMaxBytes    int64
+	spillSegmentSize int64
+	spillQueue       *spillQueue
+	spillCh          chan droppedSpan // only set with a spill queue
+	spillDone        chan struct{}
+	retry            *RetryPolicy
+	stop             chan struct{}
 	derivedReporter  reporting.WavefrontMetricsReporter
 	internalReporter reporting.WavefrontMetricsReporter
+	tracerReporter   reporting.WavefrontMetricsReporter
 
 	queueSize               metrics.Gauge
 	remCapacity             metrics.Gauge
@@ -43,6 +109,17 @@ type reporter struct {
 	spansReceived           metrics.Counter
 	spansDropped            metrics.Counter
 	spansDiscarded          metrics.Counter
+	spansDroppedOldest      metrics.Counter
+	spansDroppedTimeout     metrics.Counter
+	spansEvicted            metrics.Counter
+	spansSpilled            metrics.Counter
+	spansReplayed           metrics.Counter
+	spillDropped            metrics.Counter
+	spillSize               metrics.Gauge
+	spansRetried            metrics.Counter
+	retriesSucceeded        metrics.Counter
+	retriesExhausted        metrics.Counter
+	servicesOverflow        metrics.Counter
 	redMetricsCustomTagKeys map[string]struct{}
 }
 
@@ -50,6 +127,13 @@ var (
 	exists = struct{}{}
 )
 
+const (
+	internalMetricsPrefix          = "~sdk.go.opentracing.reporter"
+	tracerMetricsPrefix            = "~sdk.go.opentracing.tracer"
+	defaultInternalMetricsInterval = time.Minute
+	shutdownGracePeriod            = 100 * time.Millisecond
+)
+
 // Option allow WavefrontSpanReporter customization
 type Option func(*reporter)
 
@@ -68,6 +152,47 @@ func BufferSize(size int) Option {
 	}
 }
 
+// Backpressure sets the policy applied when the in-memory buffer is full. Defaults to DropNewest.
+func Backpressure(policy BackpressurePolicy) Option {
+	return func(args *reporter) {
+		args.backpressure = policy
+	}
+}
+
+// BlockTimeout sets how long ReportSpan waits for room in the buffer with the BlockWithTimeout policy.
+// Defaults to 100 milliseconds.
+func BlockTimeout(timeout time.Duration) Option {
+	return func(args *reporter) {
+		args.blockTimeout = timeout
+	}
+}
+
+// SpillQueue enables an on-disk queue in the given directory for spans that could not be sent
+// or did not fit in the in-memory buffer. Spilled spans are replayed with backoff once sends succeed
+// again, including spans left over by a previous process. The oldest spilled spans are discarded
+// once the queue exceeds maxBytes.
+func SpillQueue(dir string, maxBytes int64) Option {
+	return func(args *reporter) {
+		args.spillDir = dir
+		args.spillMaxBytes = maxBytes
+	}
+}
+
+// SpillSegmentSize sets the maximum size in bytes of a single spill queue segment file. Defaults to 4MB.
+func SpillSegmentSize(size int64) Option {
+	return func(args *reporter) {
+		args.spillSegmentSize = size
+	}
+}
+
+// Retry enables retrying spans the sender failed to send, according to the given policy.
+// Retries are disabled by default.
+func Retry(policy RetryPolicy) Option {
+	return func(args *reporter) {
+		args.retry = &policy
+	}
+}
+
 // Percent of log messages to be logged by this reporter. Between 0.0 and 1.0. Defaults to 0.1 or 10%.
 func LogPercent(percent float32) Option {
 	return func(args *reporter) {
@@ -89,6 +214,67 @@ func RedMetricsCustomTagKeys(redMetricsCustomTagKeys []string) Option {
 	}
 }
 
+// ValueEncoding sets the encoder of the values of span tags and log fields. Defaults to DefaultValueEncoder.
+func ValueEncoding(encoder ValueEncoder) Option {
+	return func(args *reporter) {
+		args.encoder = encoder
+	}
+}
+
+// MaxTrackedServices sets the maximum number of application and service combinations, overridden by span tags,
+// for which heartbeats are sent. Defaults to 100.
+func MaxTrackedServices(max int) Option {
+	return func(args *reporter) {
+		args.maxServices = max
+	}
+}
+
+// DisableHeartbeats stops the reporter from sending the heartbeat metric of the application and its services.
+func DisableHeartbeats() Option {
+	return func(args *reporter) {
+		args.heartbeatsDisabled = true
+	}
+}
+
+// HeartbeatInterval sets how often the heartbeat metric is sent. Defaults to 5 minutes.
+func HeartbeatInterval(interval time.Duration) Option {
+	return func(args *reporter) {
+		args.heartbeatInterval = interval
+	}
+}
+
+// HeartbeatComponents sets the components of the heartbeat metric, sent along with the "wavefront-generated"
+// component. Defaults to "go" and "opentracing".
+func HeartbeatComponents(components ...string) Option {
+	return func(args *reporter) {
+		args.heartbeatComponents = components
+	}
+}
+
+// DisableInternalMetrics stops the reporter from sending its internal metrics, which are still collected.
+func DisableInternalMetrics() Option {
+	return func(args *reporter) {
+		args.internalMetricsDisabled = true
+	}
+}
+
+// InternalMetricsInterval sets how often the internal metrics are sent. Defaults to 1 minute.
+func InternalMetricsInterval(interval time.Duration) Option {
+	return func(args *reporter) {
+		args.internalMetricsInterval = interval
+	}
+}
+
+// InternalMetricsRegistry registers the internal metrics in the given registry, named with the
+// "~sdk.go.opentracing.reporter." prefix and keyed like the go-metrics-wavefront reporting package does, instead
+// of a registry of their own. The reporter then does not send them, leaving it to the exporter of the registry,
+// so the application and the SDK metrics can share one exporter.
+func InternalMetricsRegistry(registry metrics.Registry) Option {
+	return func(args *reporter) {
+		args.internalRegistry = registry
+	}
+}
+
 // New returns a WavefrontSpanReporter for the given `sender`.
 func New(sender senders.Sender, app application.Tags, setters ...Option) WavefrontSpanReporter {
 	r := &reporter{
@@ -97,6 +283,14 @@ func New(sender senders.Sender, app application.Tags, setters ...Option) Wavefro
 		application:             app,
 		logPercent:              0.1,
 		bufferSize:              50000,
+		blockTimeout:            defaultBlockTimeout,
+		spillSegmentSize:        defaultSpillSegmentSize,
+		maxServices:             defaultMaxServices,
+		heartbeatInterval:       defaultHeartbeatInterval,
+		heartbeatComponents:     defaultHeartbeatComponents,
+		internalMetricsInterval: defaultInternalMetricsInterval,
+		unhealthyAfter:          defaultUnhealthyAfter,
+		encoder:                 DefaultValueEncoder,
 		redMetricsCustomTagKeys: make(map[string]struct{}),
 	}
 
@@ -105,6 +299,9 @@ func New(sender senders.Sender, app application.Tags, setters ...Option) Wavefro
 	}
 
 	r.spansCh = make(chan tracer.RawSpan, r.bufferSize)
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	r.flushReqs = make(chan chan error)
 
 	// init rand for logging
 	rand.Seed(time.Now().UnixNano())
@@ -118,34 +315,58 @@ func New(sender senders.Sender, app application.Tags, setters ...Option) Wavefro
 		reporting.CustomRegistry(metrics.NewRegistry()),
 	)
 
-	r.internalReporter = reporting.NewReporter(
-		sender,
-		r.application,
-		reporting.Interval(time.Second*60),
-		reporting.Source(r.source),
-		reporting.Prefix("~sdk.go.opentracing.reporter"),
-		reporting.CustomRegistry(metrics.NewRegistry()),
-	)
-
-	r.spansReceived = r.internalReporter.GetOrRegisterMetric(reporting.DeltaCounterName("spans.received"), metrics.NewCounter(), nil).(metrics.Counter)
-	r.spansDropped = r.internalReporter.GetOrRegisterMetric(reporting.DeltaCounterName("spans.dropped"), metrics.NewCounter(), nil).(metrics.Counter)
-	r.spansDiscarded = r.internalReporter.GetOrRegisterMetric(reporting.DeltaCounterName("spans.discarded"), metrics.NewCounter(), nil).(metrics.Counter)
-	r.errorsCount = r.internalReporter.GetOrRegisterMetric(reporting.DeltaCounterName("errors"), metrics.NewCounter(), nil).(metrics.Counter)
-
-	r.queueSize = r.internalReporter.GetOrRegisterMetric("queue.size", metrics.NewFunctionalGauge(func() int64 {
+	r.internalReporter = r.newInternalReporter(sender, internalMetricsPrefix)
+	r.tracerReporter = r.newInternalReporter(sender, tracerMetricsPrefix)
+
+	r.spansReceived = r.internalDeltaCounter("spans.received")
+	r.spansDropped = r.internalDeltaCounter("spans.dropped")
+	r.spansDiscarded = r.internalDeltaCounter("spans.discarded")
+	r.spansDroppedOldest = r.internalDeltaCounter("spans.dropped.oldest")
+	r.spansDroppedTimeout = r.internalDeltaCounter("spans.dropped.timeout")
+	r.spansEvicted = r.internalDeltaCounter("spans.evicted")
+	r.spansSpilled = r.internalDeltaCounter("spans.spilled")
+	r.spansReplayed = r.internalDeltaCounter("spans.replayed")
+	r.spillDropped = r.internalDeltaCounter("spill.dropped")
+	r.spansRetried = r.internalDeltaCounter("spans.retries")
+	r.retriesSucceeded = r.internalDeltaCounter("spans.retries.succeeded")
+	r.retriesExhausted = r.internalDeltaCounter("spans.retries.exhausted")
+	r.servicesOverflow = r.internalDeltaCounter("services.overflow")
+	r.errorsCount = r.internalDeltaCounter("errors")
+
+	r.queueSize = r.internalGauge("queue.size", func() int64 {
 		return int64(len(r.spansCh))
-	}), nil).(metrics.Gauge)
-	r.remCapacity = r.internalReporter.GetOrRegisterMetric("queue.remaining_capacity", metrics.NewFunctionalGauge(func() int64 {
+	})
+	r.remCapacity = r.internalGauge("queue.remaining_capacity", func() int64 {
 		return int64(r.bufferSize - len(r.spansCh))
-	}), nil).(metrics.Gauge)
+	})
+
+	if r.spillDir != "" {
+		queue, err := openSpillQueue(r.spillDir, r.spillMaxBytes, r.spillSegmentSize)
+		if err != nil {
+			log.Printf("error opening spill queue, spans will not be spilled: %v", err)
+		} else {
+			r.spillQueue = queue
+			r.spillSize = r.internalGauge("spill.size.bytes", func() int64 {
+				return queue.bytes()
+			})
+			r.spillCh = make(chan droppedSpan, spillBufferSize)
+			r.spillDone = make(chan struct{})
+			go r.spillDroppedSpans()
+			go r.replay()
+		}
+	}
 
-	r.heartbeater = application.StartHeartbeatService(
-		sender,
-		r.application,
-		r.source,
-		"go",
-		"opentracing",
-	)
+	startHeartbeat := func(app application.Tags) application.HeartbeatService {
+		if r.heartbeatsDisabled {
+			return noopHeartbeater{}
+		}
+		return startHeartbeater(sender, app, r.source, r.heartbeatInterval, r.heartbeatComponents...)
+	}
+	r.heartbeater = startHeartbeat(r.application)
+	r.services = newServiceRegistry(startHeartbeat, r.maxServices, r.application, r.heartbeater, r.servicesOverflow)
+	r.internalGauge("services.tracked", func() int64 {
+		return int64(r.services.size())
+	})
 
 	// kick off async span processing
 	go r.process()
@@ -153,6 +374,57 @@ func New(sender senders.Sender, app application.Tags, setters ...Option) Wavefro
 	return r
 }
 
+// newInternalReporter returns the reporter of the internal metrics with the given prefix.
+func (t *reporter) newInternalReporter(sender senders.Sender, prefix string) reporting.WavefrontMetricsReporter {
+	switch {
+	case t.internalRegistry != nil:
+		return unreportedMetrics{registry: t.internalRegistry}
+	case t.internalMetricsDisabled:
+		return unreportedMetrics{registry: metrics.NewRegistry()}
+	}
+	return reporting.NewReporter(
+		sender,
+		t.application,
+		reporting.Interval(t.internalMetricsInterval),
+		reporting.Source(t.source),
+		reporting.Prefix(prefix),
+		reporting.CustomRegistry(metrics.NewRegistry()),
+	)
+}
+
+// internalDeltaCounter registers an internal delta counter.
+func (t *reporter) internalDeltaCounter(name string) metrics.Counter {
+	name = reporting.DeltaCounterName(t.internalName(internalMetricsPrefix, name))
+	return t.internalReporter.GetOrRegisterMetric(name, &cumulativeCounter{Counter: metrics.NewCounter()}, nil).(metrics.Counter)
+}
+
+// internalGauge registers an internal gauge returning the value of f.
+func (t *reporter) internalGauge(name string, f func() int64) metrics.Gauge {
+	name = t.internalName(internalMetricsPrefix, name)
+	return t.internalReporter.GetOrRegisterMetric(name, metrics.NewFunctionalGauge(f), nil).(metrics.Gauge)
+}
+
+// TracerDeltaCounter complies with the tracer.MetricsRegistry interface.
+func (t *reporter) TracerDeltaCounter(name string, tags map[string]string) metrics.Counter {
+	name = reporting.DeltaCounterName(t.internalName(tracerMetricsPrefix, name))
+	return t.tracerReporter.GetOrRegisterMetric(name, metrics.NewCounter(), tags).(metrics.Counter)
+}
+
+// TracerGauge complies with the tracer.MetricsRegistry interface.
+func (t *reporter) TracerGauge(name string, f func() int64) metrics.Gauge {
+	name = t.internalName(tracerMetricsPrefix, name)
+	return t.tracerReporter.GetOrRegisterMetric(name, metrics.NewFunctionalGauge(f), nil).(metrics.Gauge)
+}
+
+// internalName prefixes the name of an internal metric registered in the registry of the application, since the
+// prefix is otherwise added when reporting.
+func (t *reporter) internalName(prefix, name string) string {
+	if t.internalRegistry == nil {
+		return name
+	}
+	return prefix + "." + name
+}
+
 func hostname() string {
 	name, err := os.Hostname()
 	if err != nil {
@@ -162,20 +434,84 @@ func hostname() string {
 }
 
 func (t *reporter) process() {
+	defer close(t.done)
 	for {
 		select {
 		case span, more := <-t.spansCh:
 			if !more {
-				t.done <- true
 				return
 			}
-			t.reportInternal(span)
+			t.processSpan(span)
+		case req := <-t.flushReqs:
+			t.drain()
+			req <- t.takeFailures()
 		}
 	}
 }
 
+func (t *reporter) processSpan(span tracer.RawSpan) {
+	var err error
+	select {
+	case <-t.stop:
+		// the shutdown deadline passed, keep the remaining spans from being sent.
+		t.keep(t.prepareSpan(span))
+		err = errShutdownTimeout
+	default:
+		err = t.reportInternal(span)
+	}
+	if err != nil {
+		t.failuresMtx.Lock()
+		t.failures++
+		t.lastFailure = err
+		t.failuresMtx.Unlock()
+	}
+}
+
+// drain processes the spans queued at the time of the call.
+func (t *reporter) drain() {
+drain:
+	for n := len(t.spansCh); n > 0; n-- {
+		select {
+		case span, more := <-t.spansCh:
+			if !more {
+				break drain
+			}
+			t.processSpan(span)
+		default:
+			// the remaining spans were evicted concurrently.
+			break drain
+		}
+	}
+}
+
+// takeFailures returns an error describing the spans that failed to send since the last call.
+func (t *reporter) takeFailures() error {
+	t.failuresMtx.Lock()
+	defer t.failuresMtx.Unlock()
+	if t.failures == 0 {
+		return nil
+	}
+	err := fmt.Errorf("failed to send %d spans: %v", t.failures, t.lastFailure)
+	t.failures, t.lastFailure = 0, nil
+	return err
+}
+
+func (t *reporter) isClosed() bool {
+	return atomic.LoadInt32(&t.closed) == 1
+}
+
 // ReportSpan complies with the tracer.SpanReporter interface.
 func (t *reporter) ReportSpan(span tracer.RawSpan) {
+	t.intake.RLock()
+	defer t.intake.RUnlock()
+	if t.isClosed() {
+		t.spansDropped.Inc(1)
+		if t.loggingAllowed() {
+			log.Printf("reporter closed, dropping span: %s\n", span.Operation)
+		}
+		return
+	}
+
 	t.reportDerivedMetrics(span)
 	if span.Context.IsSampled() && !*span.Context.SamplingDecision() {
 		t.spansDiscarded.Inc(1)
@@ -183,52 +519,142 @@ func (t *reporter) ReportSpan(span tracer.RawSpan) {
 	}
 
 	t.spansReceived.Inc(1)
-	select {
-	case t.spansCh <- span:
-		return
-	default:
-		t.spansDropped.Inc(1)
-		if t.loggingAllowed() {
-			log.Printf("buffer full, dropping span: %s\n", span.Operation)
-		}
-	}
+	t.enqueue(span)
 }
 
+// Close shuts down the reporter, waiting up to 5 seconds for the queued spans to be sent.
 func (t *reporter) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lost, err := t.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		return fmt.Errorf("timed out closing wavefront reporter, %d spans lost", lost)
+	}
+	if err != nil {
+		return err
+	}
+	log.Println("closed wavefront reporter")
+	return nil
+}
+
+func (t *reporter) Shutdown(ctx context.Context) (int, error) {
+	t.intake.Lock()
+	if t.isClosed() {
+		t.intake.Unlock()
+		return 0, ErrReporterClosed
+	}
+	atomic.StoreInt32(&t.closed, 1)
 	close(t.spansCh)
+	if t.spillCh != nil {
+		close(t.spillCh)
+	}
+	t.intake.Unlock()
+
+	var ctxErr error
 	select {
 	case <-t.done:
-		log.Println("closed wavefront reporter")
-	case <-time.After(5 * time.Second):
-		return fmt.Errorf("timed out closing wavefront reporter")
+		close(t.stop)
+	case <-ctx.Done():
+		ctxErr = ctx.Err()
+		// abort pending retries; the remaining spans are discarded without being sent. A send in progress is
+		// waited for no longer than the grace period, since the sender may block.
+		close(t.stop)
+		select {
+		case <-t.done:
+		case <-time.After(shutdownGracePeriod):
+		}
+	}
+	if t.spillQueue != nil {
+		select {
+		case <-t.spillDone:
+		case <-ctx.Done():
+		}
+		if err := t.spillQueue.close(ctx); err != nil && ctxErr == nil {
+			// the unsent spans stay on disk, but the sender may still be blocked
+			ctxErr = err
+		}
+	}
+
+	lost := int(atomic.LoadInt64(&t.lost)) + len(t.spansCh)
+	if lost > 0 {
+		log.Printf("wavefront reporter shut down, %d spans lost", lost)
+	}
+
+	if ctxErr != nil {
+		// the sender may still be blocked, so the metrics are neither sent nor flushed after the deadline.
+		go t.stopMetrics()
+		return lost, ctxErr
 	}
+	t.derivedReporter.Report()
+	t.internalReporter.Report()
+	t.tracerReporter.Report()
+	t.stopMetrics()
+	return lost, t.sender.Flush()
+}
+
+// stopMetrics stops the heartbeats and the reporters of the derived and internal metrics.
+func (t *reporter) stopMetrics() {
+	t.services.close()
 	t.derivedReporter.Close()
 	t.internalReporter.Close()
-	return nil
+	t.tracerReporter.Close()
 }
 
-func (t *reporter) reportInternal(span tracer.RawSpan) {
-	tags := prepareTags(span)
+// reportInternal sends the span, spilling it if sending failed.
+func (t *reporter) reportInternal(span tracer.RawSpan) error {
+	rec := t.prepareSpan(span)
+	err := t.sendWithRetry(rec)
+	if err != nil {
+		t.errorsCount.Inc(1)
+		if t.loggingAllowed() {
+			log.Printf("error reporting span: %s error: %v", span.Operation, err)
+		}
+		t.keep(rec)
+	}
+	return err
+}
+
+// keep spills a span that was not sent. Spans that cannot be spilled during shutdown are counted as lost.
+func (t *reporter) keep(rec spanRecord) {
+	if (t.spillQueue == nil || !t.spill(rec)) && t.isClosed() {
+		atomic.AddInt64(&t.lost, 1)
+	}
+}
+
+func (t *reporter) prepareSpan(span tracer.RawSpan) spanRecord {
+	tags := prepareTags(span, t.encoder)
 	parents, followsFrom := prepareReferences(span)
 
 	for k, v := range t.application.Map() {
 		// do not append if tag is already present on the span
-		if value, found := getAppTag(k, v, span.Tags); !found {
+		if value, found := getAppTag(k, v, span.Tags, t.encoder); !found {
 			tags = append(tags, senders.SpanTag{Key: k, Value: value})
 		}
 	}
-	logs := prepareLogs(span)
-
-	err := t.sender.SendSpan(span.Operation, span.Start.UnixNano()/1000000, span.Duration.Nanoseconds()/1000000,
-		t.source, span.Context.TraceID, span.Context.SpanID, parents, followsFrom, tags, logs)
-	if err != nil {
-		t.errorsCount.Inc(1)
-		if t.loggingAllowed() {
-			log.Printf("error reporting span: %s error: %v", span.Operation, err)
-		}
+	logs := prepareLogs(span, t.encoder)
+
+	return spanRecord{
+		Name:           span.Operation,
+		StartMillis:    span.Start.UnixNano() / 1000000,
+		DurationMillis: span.Duration.Nanoseconds() / 1000000,
+		Source:         t.source,
+		TraceID:        span.Context.TraceID,
+		SpanID:         span.Context.SpanID,
+		Parents:        parents,
+		FollowsFrom:    followsFrom,
+		Tags:           tags,
+		Logs:           logs,
 	}
 }
 
+func (t *reporter) send(rec spanRecord) error {
+	err := t.sender.SendSpan(rec.Name, rec.StartMillis, rec.DurationMillis, rec.Source, rec.TraceID, rec.SpanID,
+		rec.Parents, rec.FollowsFrom, rec.Tags, rec.Logs)
+	t.recordSend(err)
+	return err
+}
+
 func (t *reporter) copyTags(oriTags map[string]string) map[string]string {
 	newTags := make(map[string]string, len(oriTags)+1)
 	for key, value := range oriTags {
@@ -239,8 +665,10 @@ func (t *reporter) copyTags(oriTags map[string]string) map[string]string {
 
 func (t *reporter) reportDerivedMetrics(span tracer.RawSpan) {
 	// override application and service name if tag present
-	appName, appFound := getAppTag("application", t.application.Application, span.Tags)
-	serviceName, svcFound := getAppTag("service", t.application.Service, span.Tags)
+	appName, appFound := getAppTag("application", t.application.Application, span.Tags, t.encoder)
+	serviceName, svcFound := getAppTag("service", t.application.Service, span.Tags, t.encoder)
+	clusterName, clusterFound := getAppTag("cluster", t.application.Cluster, span.Tags, t.encoder)
+	shardName, shardFound := getAppTag("shard", t.application.Shard, span.Tags, t.encoder)
 
 	metricName := fmt.Sprintf("%s.%s.%s", appName, serviceName, span.Operation)
 	metricName = strings.Replace(metricName, " ", "-", -1)
@@ -250,20 +678,28 @@ func (t *reporter) reportDerivedMetrics(span tracer.RawSpan) {
 	tags["component"] = span.Component
 	replaceTag(tags, "application", appName, appFound)
 	replaceTag(tags, "service", serviceName, svcFound)
+	replaceTag(tags, "cluster", clusterName, clusterFound)
+	replaceTag(tags, "shard", shardName, shardFound)
+	if appFound || svcFound || clusterFound || shardFound {
+		app := t.application
+		app.Application, app.Service, app.Cluster, app.Shard = appName, serviceName, clusterName, shardName
+		t.services.track(app)
+	}
 
 	for key := range t.redMetricsCustomTagKeys {
-		if value, found := getAppTag(key, "", span.Tags); found {
+		if value, found := getAppTag(key, "", span.Tags, t.encoder); found {
 			tags[key] = value
 		}
 	}
-	err, _ := getAppTag(string(ext.Error), "false", span.Tags)
+	// the error tag is compared to "true", whatever the encoding of the values sent
+	err, _ := getAppTag(string(ext.Error), "false", span.Tags, DefaultValueEncoder)
 	isError := err == "true"
 	// add http status if span has error
-	if value, found := getAppTag(string(ext.HTTPStatusCode), "", span.Tags); found {
+	if value, found := getAppTag(string(ext.HTTPStatusCode), "", span.Tags, t.encoder); found {
 		tags[string(ext.HTTPStatusCode)] = value
 	}
 	// propagate span kind tag by default
-	tags[string(ext.SpanKind)], _ = getAppTag(string(ext.SpanKind), "none", span.Tags)
+	tags[string(ext.SpanKind)], _ = getAppTag(string(ext.SpanKind), "none", span.Tags, t.encoder)
 	t.heartbeater.AddCustomTags(tags)
 
 	// add operation tag after setting heartbeat tag
@@ -309,6 +745,30 @@ func (t *reporter) loggingAllowed() bool {
 	return rand.Float32() <= t.logPercent
 }
 
-func (t *reporter) Flush() {
+func (t *reporter) Flush(ctx context.Context) error {
+	if t.isClosed() {
+		return ErrReporterClosed
+	}
+
+	req := make(chan error, 1)
+	select {
+	case t.flushReqs <- req:
+	case <-t.done:
+		return ErrReporterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
 	t.derivedReporter.Report()
+	t.internalReporter.Report()
+	t.tracerReporter.Report()
+	return t.sender.Flush()
 }
@@ -0,0 +1,286 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+
+	"github.com/wavefronthq/wavefront-sdk-go/event"
+	"github.com/wavefronthq/wavefront-sdk-go/histogram"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+// testSender is a senders.Sender that records spans and can be made to fail.
+type testSender struct {
+	sync.Mutex
+	spans      []string
+	heartbeats []map[string]string
+	metrics    []string
+	failing    bool
+	failNext   int
+	attempts   int
+}
+
+func (s *testSender) failTimes(n int) {
+	s.Lock()
+	defer s.Unlock()
+	s.failNext = n
+}
+
+func (s *testSender) sendAttempts() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.attempts
+}
+
+func (s *testSender) setFailing(failing bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.failing = failing
+}
+
+func (s *testSender) spanNames() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string(nil), s.spans...)
+}
+
+func (s *testSender) SendSpan(name string, startMillis, durationMillis int64, source, traceId, spanId string,
+	parents, followsFrom []string, tags []senders.SpanTag, spanLogs []senders.SpanLog) error {
+	s.Lock()
+	defer s.Unlock()
+	s.attempts++
+	if s.failing || s.failNext > 0 {
+		s.failNext--
+		return errors.New("sender unavailable")
+	}
+	s.spans = append(s.spans, name)
+	return nil
+}
+
+func (s *testSender) SendMetric(name string, value float64, ts int64, source string, tags map[string]string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.metrics = append(s.metrics, name)
+	if name == "~component.heartbeat" {
+		heartbeat := make(map[string]string, len(tags))
+		for k, v := range tags {
+			heartbeat[k] = v
+		}
+		s.heartbeats = append(s.heartbeats, heartbeat)
+	}
+	return nil
+}
+
+// heartbeatServices returns the distinct "application/service/cluster/shard" of the heartbeats of the given component.
+func (s *testSender) heartbeatServices(component string) []string {
+	s.Lock()
+	defer s.Unlock()
+	var services []string
+	seen := make(map[string]bool)
+	for _, tags := range s.heartbeats {
+		service := tags["application"] + "/" + tags["service"] + "/" + tags["cluster"] + "/" + tags["shard"]
+		if tags["component"] == component && !seen[service] {
+			seen[service] = true
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+func (s *testSender) SendDeltaCounter(name string, value float64, source string, tags map[string]string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.metrics = append(s.metrics, name)
+	return nil
+}
+
+// metricNames returns the names of the metrics and delta counters sent with the given prefix.
+func (s *testSender) metricNames(prefix string) []string {
+	s.Lock()
+	defer s.Unlock()
+	var names []string
+	for _, name := range s.metrics {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *testSender) heartbeatCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.heartbeats)
+}
+
+func (s *testSender) SendDistribution(name string, centroids []histogram.Centroid, hgs map[histogram.Granularity]bool,
+	ts int64, source string, tags map[string]string) error {
+	return nil
+}
+
+func (s *testSender) SendEvent(name string, startMillis, endMillis int64, source string, tags map[string]string,
+	setters ...event.Option) error {
+	return nil
+}
+
+func (s *testSender) Flush() error {
+	return nil
+}
+
+func (s *testSender) GetFailureCount() int64 {
+	return 0
+}
+
+func (s *testSender) Start() {}
+
+func (s *testSender) Close() {}
+
+func newSpan(operation string) tracer.RawSpan {
+	return tracer.RawSpan{
+		Context: tracer.SpanContext{
+			TraceID: uuid.New().String(),
+			SpanID:  uuid.New().String(),
+		},
+		Operation: operation,
+		Component: "test",
+		Start:     time.Now(),
+		Duration:  time.Millisecond,
+	}
+}
+
+func TestReporter_ShutdownDrainsQueue(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"))
+	for i := 0; i < 100; i++ {
+		r.ReportSpan(newSpan("span"))
+	}
+
+	lost, err := r.Shutdown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, lost)
+	assert.Len(t, sender.spanNames(), 100)
+
+	r.ReportSpan(newSpan("late"))
+	assert.Len(t, sender.spanNames(), 100)
+
+	_, err = r.Shutdown(context.Background())
+	assert.Equal(t, ErrReporterClosed, err)
+}
+
+func TestReporter_ShutdownDeadline(t *testing.T) {
+	sender := &testSender{failing: true}
+	policy := DefaultRetryPolicy()
+	policy.MaxAttempts = 100
+	r := New(sender, application.New("app", "service"), Retry(policy))
+	for i := 0; i < 10; i++ {
+		r.ReportSpan(newSpan("span"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	lost, err := r.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 10, lost)
+}
+
+// blockingSender is a testSender whose span sends block until released.
+type blockingSender struct {
+	testSender
+	release chan struct{}
+	sends   int32
+	flushes int32
+}
+
+func (s *blockingSender) SendSpan(name string, startMillis, durationMillis int64, source, traceId, spanId string,
+	parents, followsFrom []string, tags []senders.SpanTag, spanLogs []senders.SpanLog) error {
+	atomic.AddInt32(&s.sends, 1)
+	<-s.release
+	return s.testSender.SendSpan(name, startMillis, durationMillis, source, traceId, spanId, parents, followsFrom,
+		tags, spanLogs)
+}
+
+func (s *blockingSender) Flush() error {
+	atomic.AddInt32(&s.flushes, 1)
+	return nil
+}
+
+func TestReporter_ShutdownDeadlineBlockedSender(t *testing.T) {
+	sender := &blockingSender{release: make(chan struct{})}
+	defer close(sender.release)
+	r := New(sender, application.New("app", "service"), DisableHeartbeats())
+	for i := 0; i < 10; i++ {
+		r.ReportSpan(newSpan("span"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	lost, err := r.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "the blocked send is not waited for")
+	assert.GreaterOrEqual(t, lost, 9, "the queued spans are lost")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&sender.flushes), "the sender is not flushed after the deadline")
+}
+
+func TestReporter_ShutdownDeadlineBlockedReplay(t *testing.T) {
+	dir := tempSpillDir(t)
+	q, err := openSpillQueue(dir, 1<<20, 1<<10)
+	require.NoError(t, err)
+	_, err = q.append(spanRecord{Name: "spilled"})
+	require.NoError(t, err)
+	q.file.Close()
+
+	sender := &blockingSender{release: make(chan struct{})}
+	defer close(sender.release)
+	r := New(sender, application.New("app", "service"), SpillQueue(dir, 1<<20), DisableHeartbeats())
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&sender.sends) > 0 }, time.Second, time.Millisecond,
+		"the spilled span is being replayed")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	lost, err := r.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "the blocked replay is not waited for")
+	assert.Equal(t, 0, lost, "the spilled span stays on disk")
+}
+
+func TestReporter_ValueEncodingOfAppTags(t *testing.T) {
+	sender := &testSender{}
+	encoder := func(value interface{}) string { return strings.ToUpper(DefaultValueEncoder(value)) }
+	r := New(sender, application.New("app", "service"), ValueEncoding(encoder), DisableHeartbeats())
+	defer r.Close()
+
+	span := newSpan("op")
+	span.Tags = map[string]interface{}{"application": "shop"}
+	r.ReportSpan(span)
+	require.NoError(t, r.Flush(context.Background()))
+	assert.Contains(t, sender.metricNames("∆tracing.derived."), "∆tracing.derived.SHOP.service.op.invocation.count")
+}
+
+func TestReporter_Flush(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"))
+	defer r.Close()
+
+	for i := 0; i < 100; i++ {
+		r.ReportSpan(newSpan("span"))
+	}
+	require.NoError(t, r.Flush(context.Background()))
+	assert.Len(t, sender.spanNames(), 100)
+
+	sender.setFailing(true)
+	r.ReportSpan(newSpan("failed"))
+	assert.Error(t, r.Flush(context.Background()))
+}
@@ -0,0 +1,136 @@
+package reporter
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how the reporter retries spans the sender failed to send.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of send attempts, including the first one.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between retries.
+	MaxBackoff time.Duration
+
+	// Multiplier grows the wait after each retry.
+	Multiplier float64
+
+	// Jitter randomizes each wait by up to the given fraction of it. Between 0.0 and 1.0.
+	Jitter float64
+
+	// Retryable reports whether a send error is transient. Defaults to IsRetryable.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy returns a RetryPolicy making up to 3 attempts, starting with a 100ms backoff.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// permanentErrors are the sender errors caused by the span itself or the sender configuration,
+// which fail the same way no matter how often they are retried. The Wavefront SDK does not export error types
+// or sentinel values, so they are recognized by the text of their messages, which the tests check against the
+// errors returned by the SDK.
+var permanentErrors = []string{
+	"span name cannot be empty",
+	"is not in UUID format",
+	"tag keys cannot be empty",
+	"tag values cannot be empty",
+	"port not provided",
+}
+
+// IsRetryable reports whether the sender error is transient, such as a connection failure or a full sender buffer.
+// Errors of the Wavefront SDK are classified by their message, since the SDK does not expose their types: an
+// invalid span or a missing proxy tracing port is permanent, any other error is retried. Set RetryPolicy.Retryable
+// to classify the errors of other senders.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, permanent := range permanentErrors {
+		if strings.Contains(msg, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff returns the wait before the given retry, starting at 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		backoff *= p.Multiplier
+		if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// do calls fn until it succeeds, fails with an error that is not retryable, the attempts are exhausted
+// or stop is closed. It returns the number of retries made and the last error.
+func (p RetryPolicy) do(stop <-chan struct{}, fn func() error) (int, error) {
+	err := fn()
+	retries := 0
+	for ; err != nil && retries+1 < p.MaxAttempts && p.retryable(err); retries++ {
+		timer := time.NewTimer(p.backoff(retries + 1))
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return retries, err
+		}
+		err = fn()
+	}
+	return retries, err
+}
+
+// exhausted reports whether the error returned by do after the given number of retries
+// is a transient error that was retried as many times as allowed.
+func (p RetryPolicy) exhausted(retries int, err error) bool {
+	return err != nil && retries+1 >= p.MaxAttempts && p.retryable(err)
+}
+
+// sendWithRetry sends the span record, retrying transient failures according to the retry policy.
+func (t *reporter) sendWithRetry(rec spanRecord) error {
+	if t.retry == nil {
+		return t.send(rec)
+	}
+
+	retries, err := t.retry.do(t.stop, func() error { return t.send(rec) })
+	if retries > 0 {
+		t.spansRetried.Inc(int64(retries))
+		if err == nil {
+			t.retriesSucceeded.Inc(1)
+		}
+	}
+	if t.retry.exhausted(retries, err) {
+		t.retriesExhausted.Inc(1)
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package reporter
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+func newRetryingReporter(sender *testSender, maxAttempts int) *reporter {
+	policy := DefaultRetryPolicy()
+	policy.MaxAttempts = maxAttempts
+	policy.InitialBackoff = time.Millisecond
+	return &reporter{
+		sender:           sender,
+		retry:            &policy,
+		stop:             make(chan struct{}),
+		errorsCount:      metrics.NewCounter(),
+		spansRetried:     metrics.NewCounter(),
+		retriesSucceeded: metrics.NewCounter(),
+		retriesExhausted: metrics.NewCounter(),
+	}
+}
+
+func TestRetry_EventualSuccess(t *testing.T) {
+	sender := &testSender{}
+	sender.failTimes(2)
+	r := newRetryingReporter(sender, 3)
+
+	r.reportInternal(newSpan("a"))
+	assert.Equal(t, []string{"a"}, sender.spanNames())
+	assert.Equal(t, int64(2), r.spansRetried.Count())
+	assert.Equal(t, int64(1), r.retriesSucceeded.Count())
+	assert.Equal(t, int64(0), r.retriesExhausted.Count())
+	assert.Equal(t, int64(0), r.errorsCount.Count())
+}
+
+func TestRetry_Exhausted(t *testing.T) {
+	sender := &testSender{failing: true}
+	r := newRetryingReporter(sender, 3)
+
+	r.reportInternal(newSpan("a"))
+	assert.Equal(t, 3, sender.sendAttempts())
+	assert.Equal(t, int64(2), r.spansRetried.Count())
+	assert.Equal(t, int64(1), r.retriesExhausted.Count())
+	assert.Equal(t, int64(1), r.errorsCount.Count())
+}
+
+func TestRetry_NotRetryable(t *testing.T) {
+	sender := &testSender{}
+	r := newRetryingReporter(sender, 3)
+
+	sender.failTimes(1)
+	r.retry.Retryable = func(err error) bool { return false }
+	r.reportInternal(newSpan("a"))
+	assert.Equal(t, 1, sender.sendAttempts())
+	assert.Equal(t, int64(0), r.spansRetried.Count())
+	assert.Equal(t, int64(0), r.retriesExhausted.Count())
+	assert.Equal(t, int64(1), r.errorsCount.Count())
+}
+
+// TestIsRetryable checks the errors returned by the Wavefront SDK, since IsRetryable depends on their messages.
+func TestIsRetryable(t *testing.T) {
+	assert.False(t, IsRetryable(nil))
+
+	id := uuid.New().String()
+	spanLineError := func(name, traceID string, tags []senders.SpanTag) error {
+		_, err := senders.SpanLine(name, 0, 1, "source", traceID, id, nil, nil, tags, nil, "source")
+		require.Error(t, err)
+		return err
+	}
+	assert.False(t, IsRetryable(spanLineError("", id, nil)), "empty span name")
+	assert.False(t, IsRetryable(spanLineError("op", "not-a-uuid", nil)), "invalid trace id")
+	assert.False(t, IsRetryable(spanLineError("op", id, []senders.SpanTag{{Key: "", Value: "v"}})), "empty tag key")
+	assert.False(t, IsRetryable(spanLineError("op", id, []senders.SpanTag{{Key: "k", Value: ""}})), "empty tag value")
+
+	// a closed port, refusing connections
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+
+	noTracing, err := senders.NewProxySender(&senders.ProxyConfiguration{Host: "127.0.0.1", MetricsPort: port})
+	require.NoError(t, err)
+	defer noTracing.Close()
+	err = noTracing.SendSpan("op", 0, 1, "source", id, id, nil, nil, nil, nil)
+	require.Error(t, err)
+	assert.False(t, IsRetryable(err), "no tracing port")
+
+	unreachable, err := senders.NewProxySender(&senders.ProxyConfiguration{Host: "127.0.0.1", TracingPort: port})
+	require.NoError(t, err)
+	defer unreachable.Close()
+	err = unreachable.SendSpan("op", 0, 1, "source", id, id, nil, nil, nil, nil)
+	require.Error(t, err)
+	assert.True(t, IsRetryable(err), "connection refused")
+
+	full, err := senders.NewDirectSender(&senders.DirectConfiguration{
+		Server:               "http://127.0.0.1:" + strconv.Itoa(port),
+		Token:                "token",
+		MaxBufferSize:        1,
+		FlushIntervalSeconds: 3600,
+	})
+	require.NoError(t, err)
+	defer full.Close()
+	require.NoError(t, full.SendSpan("op", 0, 1, "source", id, id, nil, nil, nil, nil))
+	err = full.SendSpan("op", 0, 1, "source", id, id, nil, nil, nil, nil)
+	require.Error(t, err)
+	assert.True(t, IsRetryable(err), "buffer full")
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 400*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, time.Second, policy.backoff(10))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.backoff(1)
+		assert.True(t, backoff >= 50*time.Millisecond && backoff <= 150*time.Millisecond)
+	}
+}
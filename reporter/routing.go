@@ -0,0 +1,295 @@
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+const defaultRouteName = "default"
+
+// SpanPredicate reports whether a span matches a condition.
+type SpanPredicate func(span tracer.RawSpan) bool
+
+// Route sends the spans matching a predicate to a reporter.
+type Route struct {
+	// Name identifies the route in the metrics, named "<name>.spans".
+	Name string
+
+	Match    SpanPredicate
+	Reporter tracer.SpanReporter
+}
+
+// MatchApplication matches the spans of the given applications, set by the "application" tag.
+// See RoutingApplication for the spans without the tag.
+func MatchApplication(names ...string) SpanPredicate {
+	return matchAppTag("application", names)
+}
+
+// MatchService matches the spans of the given services, set by the "service" tag.
+// See RoutingApplication for the spans without the tag.
+func MatchService(names ...string) SpanPredicate {
+	return matchAppTag("service", names)
+}
+
+func matchAppTag(key string, names []string) SpanPredicate {
+	return func(span tracer.RawSpan) bool {
+		value, _ := getAppTag(key, "", span.Tags, DefaultValueEncoder)
+		return contains(names, value)
+	}
+}
+
+// MatchOperation matches the spans with the given operation names.
+func MatchOperation(names ...string) SpanPredicate {
+	return func(span tracer.RawSpan) bool {
+		return contains(names, span.Operation)
+	}
+}
+
+// MatchComponent matches the spans of the given components.
+func MatchComponent(names ...string) SpanPredicate {
+	return func(span tracer.RawSpan) bool {
+		return contains(names, span.Component)
+	}
+}
+
+// MatchTag matches the spans with the given tag value.
+func MatchTag(key, value string) SpanPredicate {
+	return func(span tracer.RawSpan) bool {
+		v, found := getAppTag(key, "", span.Tags, DefaultValueEncoder)
+		return found && v == value
+	}
+}
+
+// MatchSampled matches the sampled spans if sampled is true, and the spans that are not sampled otherwise.
+func MatchSampled(sampled bool) SpanPredicate {
+	return func(span tracer.RawSpan) bool {
+		return isSampled(span) == sampled
+	}
+}
+
+// MatchAll matches the spans matching all the predicates.
+func MatchAll(predicates ...SpanPredicate) SpanPredicate {
+	return func(span tracer.RawSpan) bool {
+		for _, predicate := range predicates {
+			if !predicate(span) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAny matches the spans matching any of the predicates.
+func MatchAny(predicates ...SpanPredicate) SpanPredicate {
+	return func(span tracer.RawSpan) bool {
+		for _, predicate := range predicates {
+			if predicate(span) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchNot matches the spans not matching the predicate.
+func MatchNot(predicate SpanPredicate) SpanPredicate {
+	return func(span tracer.RawSpan) bool {
+		return !predicate(span)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type routingConfig struct {
+	defaultReporter tracer.SpanReporter
+	app             *application.Tags
+	registry        metrics.Registry
+}
+
+// RoutingOption allows customizing the routing span reporter.
+type RoutingOption func(*routingConfig)
+
+// RoutingDefault sets the reporter of the spans matching no route. By default, those spans are dropped.
+func RoutingDefault(reporter tracer.SpanReporter) RoutingOption {
+	return func(cfg *routingConfig) {
+		cfg.defaultReporter = reporter
+	}
+}
+
+// RoutingApplication sets the application tags used by MatchApplication and MatchService for the spans without
+// "application" or "service" tag. Use the same tags as the WavefrontSpanReporter.
+func RoutingApplication(app application.Tags) RoutingOption {
+	return func(cfg *routingConfig) {
+		cfg.app = &app
+	}
+}
+
+// RoutingMetricsRegistry sets the registry of the routing metrics: "<route>.spans" for each route,
+// "default.spans", and "unrouted.spans" for the spans dropped when there is no default reporter. The metrics of
+// the CompositeSpanReporter are registered too, named after the first route of each reporter, such as
+// "<route>.spans.dropped" for the spans reported after Close. Defaults to a new registry.
+func RoutingMetricsRegistry(registry metrics.Registry) RoutingOption {
+	return func(cfg *routingConfig) {
+		cfg.registry = registry
+	}
+}
+
+type routingReporter struct {
+	routes   []route
+	fallback *route // nil if there is no default reporter
+	app      *application.Tags
+	unrouted metrics.Counter
+
+	// reporters holds the distinct reporters, which are flushed and shut down in parallel
+	reporters CompositeSpanReporter
+}
+
+type route struct {
+	match  SpanPredicate
+	child  *compositeChild
+	routed metrics.Counter
+}
+
+// NewRoutingSpanReporter returns a SpanReporter reporting each span to the reporter of the first route matching
+// it, or to the default reporter if no route matches. Panics in the reporters are recovered. Flush, Shutdown and
+// Close are passed through to the distinct reporters in parallel, as done by the CompositeSpanReporter.
+// It panics if a route has no Match or no Reporter.
+func NewRoutingSpanReporter(routes []Route, options ...RoutingOption) tracer.SpanReporter {
+	for i, rt := range routes {
+		if rt.Match == nil {
+			panic(fmt.Sprintf("routing reporter: route %d %q has no Match", i, rt.Name))
+		}
+		if rt.Reporter == nil {
+			panic(fmt.Sprintf("routing reporter: route %d %q has no Reporter", i, rt.Name))
+		}
+	}
+
+	cfg := routingConfig{}
+	for _, option := range options {
+		option(&cfg)
+	}
+	if cfg.registry == nil {
+		cfg.registry = metrics.NewRegistry()
+	}
+
+	// the same reporter may be used by several routes
+	var reporters []tracer.SpanReporter
+	var names []string
+	indexOf := func(reporter tracer.SpanReporter, name string) int {
+		for i, r := range reporters {
+			if sameReporter(r, reporter) {
+				return i
+			}
+		}
+		reporters = append(reporters, reporter)
+		names = append(names, name)
+		return len(reporters) - 1
+	}
+	indexes := make([]int, len(routes))
+	for i, rt := range routes {
+		indexes[i] = indexOf(rt.Reporter, rt.Name)
+	}
+	defaultIndex := -1
+	if cfg.defaultReporter != nil {
+		defaultIndex = indexOf(cfg.defaultReporter, defaultRouteName)
+	}
+
+	c := NewCompositeSpanReporterWithOptions(reporters, CompositeNames(names...), CompositeMetricsRegistry(cfg.registry))
+	r := &routingReporter{
+		app:       cfg.app,
+		unrouted:  metrics.GetOrRegisterCounter("unrouted.spans", cfg.registry),
+		reporters: c.(CompositeSpanReporter),
+	}
+	for i, rt := range routes {
+		r.routes = append(r.routes, route{
+			match:  rt.Match,
+			child:  r.reporters.children[indexes[i]],
+			routed: metrics.GetOrRegisterCounter(rt.Name+".spans", cfg.registry),
+		})
+	}
+	if defaultIndex >= 0 {
+		r.fallback = &route{
+			child:  r.reporters.children[defaultIndex],
+			routed: metrics.GetOrRegisterCounter(defaultRouteName+".spans", cfg.registry),
+		}
+	}
+	return r
+}
+
+// sameReporter compares the reporters without panicking on reporters of uncomparable types.
+func sameReporter(a, b tracer.SpanReporter) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// ReportSpan complies with the `tracer.SpanReporter` interface.
+func (r *routingReporter) ReportSpan(span tracer.RawSpan) {
+	tagged := r.withAppTags(span)
+	matched := r.fallback
+	for i := range r.routes {
+		if r.routes[i].match(tagged) {
+			matched = &r.routes[i]
+			break
+		}
+	}
+	if matched == nil {
+		r.unrouted.Inc(1)
+		return
+	}
+	matched.routed.Inc(1)
+	r.reporters.reportTo(matched.child, span)
+}
+
+// withAppTags returns the span with the application tags added to its tags when missing, for matching.
+func (r *routingReporter) withAppTags(span tracer.RawSpan) tracer.RawSpan {
+	if r.app == nil {
+		return span
+	}
+	_, hasApp := span.Tags["application"]
+	_, hasService := span.Tags["service"]
+	if hasApp && hasService {
+		return span
+	}
+	tags := make(map[string]interface{}, len(span.Tags)+2)
+	for k, v := range span.Tags {
+		tags[k] = v
+	}
+	if !hasApp {
+		tags["application"] = r.app.Application
+	}
+	if !hasService {
+		tags["service"] = r.app.Service
+	}
+	span.Tags = tags
+	return span
+}
+
+// Flush flushes the reporters supporting Flush. It returns a MultiError of the errors of the reporters.
+func (r *routingReporter) Flush(ctx context.Context) error {
+	return r.reporters.Flush(ctx)
+}
+
+// Shutdown shuts down the reporters, calling Close on the reporters not supporting Shutdown. It returns the
+// total number of spans lost, and a MultiError of the errors of the reporters.
+func (r *routingReporter) Shutdown(ctx context.Context) (int, error) {
+	return r.reporters.Shutdown(ctx)
+}
+
+// Close closes the reporters. It returns a MultiError of the errors of the reporters.
+func (r *routingReporter) Close() error {
+	return r.reporters.Close()
+}
@@ -0,0 +1,134 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+func spanWithTags(operation string, tags opentracing.Tags) tracer.RawSpan {
+	span := newSpan(operation)
+	span.Tags = tags
+	return span
+}
+
+func TestRoutingSpanReporter(t *testing.T) {
+	registry := metrics.NewRegistry()
+	audit, debug, fallback := &recordingReporter{}, &recordingReporter{}, &recordingReporter{}
+	r := NewRoutingSpanReporter([]Route{
+		{Name: "audit", Match: MatchService("payments", "billing"), Reporter: audit},
+		{Name: "debug", Match: MatchAny(MatchTag("debug", "true"), MatchOperation("healthcheck")), Reporter: debug},
+	}, RoutingDefault(fallback), RoutingApplication(application.New("shop", "frontend")), RoutingMetricsRegistry(registry))
+
+	r.ReportSpan(spanWithTags("charge", opentracing.Tags{"service": "payments", "debug": "true"}))
+	r.ReportSpan(spanWithTags("invoice", opentracing.Tags{"service": "billing"}))
+	r.ReportSpan(spanWithTags("render", opentracing.Tags{"debug": true}))
+	r.ReportSpan(newSpan("healthcheck"))
+	r.ReportSpan(newSpan("checkout"))
+
+	assert.Equal(t, []string{"charge", "invoice"}, audit.spanNames())
+	assert.Equal(t, []string{"render", "healthcheck"}, debug.spanNames())
+	assert.Equal(t, []string{"checkout"}, fallback.spanNames())
+	assert.Equal(t, int64(2), registry.Get("audit.spans").(metrics.Counter).Count())
+	assert.Equal(t, int64(2), registry.Get("debug.spans").(metrics.Counter).Count())
+	assert.Equal(t, int64(1), registry.Get("default.spans").(metrics.Counter).Count())
+	assert.Equal(t, int64(0), registry.Get("unrouted.spans").(metrics.Counter).Count())
+}
+
+func TestRoutingSpanReporter_ApplicationTags(t *testing.T) {
+	matched := &recordingReporter{}
+	r := NewRoutingSpanReporter([]Route{
+		{Name: "frontend", Match: MatchAll(MatchApplication("shop"), MatchService("frontend")), Reporter: matched},
+	}, RoutingApplication(application.New("shop", "frontend")))
+
+	r.ReportSpan(newSpan("default-app"))
+	r.ReportSpan(spanWithTags("other-service", opentracing.Tags{"service": "backend"}))
+
+	assert.Equal(t, []string{"default-app"}, matched.spanNames())
+	span := newSpan("no-app-tags")
+	NewRoutingSpanReporter([]Route{{Name: "any", Match: MatchService("frontend"), Reporter: matched}}).ReportSpan(span)
+	assert.Nil(t, span.Tags, "the span tags must not be modified")
+	assert.Equal(t, []string{"default-app"}, matched.spanNames())
+}
+
+func TestRoutingSpanReporter_Unrouted(t *testing.T) {
+	registry := metrics.NewRegistry()
+	sampled, unsampled := &recordingReporter{}, &recordingReporter{panics: true}
+	r := NewRoutingSpanReporter([]Route{
+		{Name: "unsampled", Match: MatchSampled(false), Reporter: unsampled},
+		{Name: "components", Match: MatchAll(MatchSampled(true), MatchComponent("grpc")), Reporter: sampled},
+	}, RoutingMetricsRegistry(registry))
+
+	notSampled := newSpan("not-sampled")
+	decision := false
+	notSampled.Context.Sampled = &decision
+	r.ReportSpan(notSampled)
+	grpc := newSpan("grpc-call")
+	grpc.Component = "grpc"
+	r.ReportSpan(grpc)
+	r.ReportSpan(newSpan("http-call"))
+
+	assert.Equal(t, []string{"grpc-call"}, sampled.spanNames())
+	assert.Equal(t, int64(1), registry.Get("unsampled.spans").(metrics.Counter).Count())
+	assert.Equal(t, int64(1), registry.Get("unrouted.spans").(metrics.Counter).Count())
+}
+
+func TestRoutingSpanReporter_Shutdown(t *testing.T) {
+	errShared := errors.New("shutdown failed")
+	shared := &flushingReporter{recordingReporter: recordingReporter{err: errShared}, lost: 2}
+	other := &recordingReporter{}
+	r := NewRoutingSpanReporter([]Route{
+		{Name: "a", Match: MatchOperation("a"), Reporter: shared},
+		{Name: "b", Match: MatchOperation("b"), Reporter: shared},
+	}, RoutingDefault(other)).(*routingReporter)
+
+	r.ReportSpan(newSpan("a"))
+	r.ReportSpan(newSpan("b"))
+	assert.Equal(t, []string{"a", "b"}, shared.spanNames())
+
+	err := r.Flush(context.Background())
+	assert.True(t, errors.Is(err, errShared))
+	assert.Equal(t, 1, shared.flushed)
+
+	lost, err := r.Shutdown(context.Background())
+	assert.Equal(t, 2, lost, "the shared reporter is shut down once")
+	require.Error(t, err)
+	assert.Equal(t, "a: shutdown failed", err.Error())
+	assert.True(t, other.closed)
+}
+
+func TestRoutingSpanReporter_ReportAfterClose(t *testing.T) {
+	registry := metrics.NewRegistry()
+	routed, fallback := &recordingReporter{}, &recordingReporter{}
+	r := NewRoutingSpanReporter([]Route{{Name: "routed", Match: MatchOperation("a"), Reporter: routed}},
+		RoutingDefault(fallback), RoutingMetricsRegistry(registry))
+
+	r.ReportSpan(newSpan("a"))
+	require.NoError(t, r.Close())
+	r.ReportSpan(newSpan("a"))
+	r.ReportSpan(newSpan("b"))
+
+	assert.Equal(t, []string{"a"}, routed.spanNames())
+	assert.Empty(t, fallback.spanNames(), "the spans are not reported to closed reporters")
+	assert.Equal(t, int64(1), registry.Get("routed.spans.received").(metrics.Counter).Count())
+	assert.Equal(t, int64(1), registry.Get("routed.spans.dropped").(metrics.Counter).Count())
+	assert.Equal(t, int64(1), registry.Get("default.spans.dropped").(metrics.Counter).Count())
+}
+
+func TestRoutingSpanReporter_InvalidRoutes(t *testing.T) {
+	match := func(span tracer.RawSpan) bool { return true }
+	assert.PanicsWithValue(t, `routing reporter: route 0 "billing" has no Match`, func() {
+		NewRoutingSpanReporter([]Route{{Name: "billing", Reporter: &recordingReporter{}}})
+	})
+	assert.PanicsWithValue(t, `routing reporter: route 1 "audit" has no Reporter`, func() {
+		NewRoutingSpanReporter([]Route{{Name: "billing", Match: match, Reporter: &recordingReporter{}},
+			{Name: "audit", Match: match}})
+	})
+}
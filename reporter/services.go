@@ -0,0 +1,109 @@
+package reporter
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+const defaultMaxServices = 100
+
+type serviceKey struct {
+	application, service, cluster, shard string
+}
+
+func keyOf(app application.Tags) serviceKey {
+	return serviceKey{application: app.Application, service: app.Service, cluster: app.Cluster, shard: app.Shard}
+}
+
+// serviceRegistry tracks the distinct application, service, cluster and shard combinations of the reported spans,
+// which spans can override with tags, and runs a heartbeat service for each of them so they show up as live.
+type serviceRegistry struct {
+	startHeartbeat func(app application.Tags) application.HeartbeatService
+	max            int
+	overflow       metrics.Counter
+
+	mtx        sync.Mutex // protects the fields below
+	services   map[serviceKey]application.Tags
+	heartbeats map[serviceKey]application.HeartbeatService
+	overflowed bool
+	closed     bool
+}
+
+// newServiceRegistry returns a registry tracking the primary application, whose heartbeat service is already started.
+func newServiceRegistry(startHeartbeat func(app application.Tags) application.HeartbeatService, max int,
+	primary application.Tags, heartbeater application.HeartbeatService, overflow metrics.Counter) *serviceRegistry {
+	key := keyOf(primary)
+	return &serviceRegistry{
+		startHeartbeat: startHeartbeat,
+		max:            max,
+		overflow:       overflow,
+		services:       map[serviceKey]application.Tags{key: primary},
+		heartbeats:     map[serviceKey]application.HeartbeatService{key: heartbeater},
+	}
+}
+
+// track starts a heartbeat service for the application if it is not tracked yet. Applications beyond the
+// maximum number of tracked services are counted but not tracked.
+func (r *serviceRegistry) track(app application.Tags) {
+	key := keyOf(app)
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, found := r.services[key]; found || r.closed {
+		return
+	}
+	if len(r.services) >= r.max {
+		r.overflow.Inc(1)
+		if !r.overflowed {
+			r.overflowed = true
+			log.Printf("wavefront reporter tracking %d services, not sending heartbeats for %s.%s", len(r.services),
+				app.Application, app.Service)
+		}
+		return
+	}
+	r.services[key] = app
+	r.heartbeats[key] = r.startHeartbeat(app)
+}
+
+// list returns the tracked applications, sorted.
+func (r *serviceRegistry) list() []application.Tags {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	apps := make([]application.Tags, 0, len(r.services))
+	for _, app := range r.services {
+		apps = append(apps, app)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		a, b := keyOf(apps[i]), keyOf(apps[j])
+		if a.application != b.application {
+			return a.application < b.application
+		}
+		if a.service != b.service {
+			return a.service < b.service
+		}
+		if a.cluster != b.cluster {
+			return a.cluster < b.cluster
+		}
+		return a.shard < b.shard
+	})
+	return apps
+}
+
+func (r *serviceRegistry) size() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return len(r.services)
+}
+
+// close stops the heartbeat services.
+func (r *serviceRegistry) close() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.closed = true
+	for _, heartbeat := range r.heartbeats {
+		heartbeat.Close()
+	}
+}
@@ -0,0 +1,83 @@
+package reporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+func TestReporter_HeartbeatsForOverriddenServices(t *testing.T) {
+	sender := &testSender{}
+	app := application.New("app", "service")
+	app.Cluster = "us-west"
+	r := New(sender, app)
+
+	spans := []tracer.RawSpan{
+		spanWithTags("default", nil),
+		spanWithTags("other-service", opentracing.Tags{"service": "billing"}),
+		spanWithTags("other-service-again", opentracing.Tags{"service": "billing"}),
+		spanWithTags("other-app", opentracing.Tags{"application": "shop", "service": "cart"}),
+		spanWithTags("other-shard", opentracing.Tags{"service": "billing", "shard": "primary"}),
+		spanWithTags("same-as-default", opentracing.Tags{"service": "service"}),
+	}
+	for _, span := range spans {
+		r.ReportSpan(span)
+	}
+
+	assert.Equal(t, []application.Tags{
+		{Application: "app", Service: "billing", Cluster: "us-west", Shard: "none", CustomTags: app.CustomTags},
+		{Application: "app", Service: "billing", Cluster: "us-west", Shard: "primary", CustomTags: app.CustomTags},
+		app,
+		{Application: "shop", Service: "cart", Cluster: "us-west", Shard: "none", CustomTags: app.CustomTags},
+	}, r.(*reporter).services.list())
+	// the heartbeats are sent asynchronously
+	assert.Eventually(t, func() bool { return len(sender.heartbeatServices("opentracing")) == 4 },
+		5*time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{
+		"app/service/us-west/none",
+		"app/billing/us-west/none",
+		"shop/cart/us-west/none",
+		"app/billing/us-west/primary",
+	}, sender.heartbeatServices("opentracing"))
+
+	_, err := r.Shutdown(context.Background())
+	require.NoError(t, err)
+}
+
+func TestReporter_DerivedMetricsClusterShardOverrides(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service")).(*reporter)
+	defer r.Close()
+
+	r.ReportSpan(spanWithTags("op", opentracing.Tags{"cluster": "eu", "shard": "2"}))
+
+	counter := r.derivedReporter.GetMetric("∆app.service.op.invocation", map[string]string{
+		"application": "app", "service": "service", "cluster": "eu", "shard": "2",
+		"component": "test", "span.kind": "none", "operationName": "op",
+	})
+	require.NotNil(t, counter)
+	assert.Equal(t, int64(1), counter.(metrics.Counter).Count())
+}
+
+func TestReporter_MaxTrackedServices(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"), MaxTrackedServices(2)).(*reporter)
+	defer r.Close()
+
+	for _, service := range []string{"a", "b", "c", "a"} {
+		r.ReportSpan(spanWithTags("op", opentracing.Tags{"service": service}))
+	}
+
+	assert.Equal(t, 2, r.services.size())
+	assert.Equal(t, int64(2), r.servicesOverflow.Count())
+	assert.Eventually(t, func() bool { return len(sender.heartbeatServices("go")) == 2 },
+		5*time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{"app/service/none/none", "app/a/none/none"}, sender.heartbeatServices("go"))
+}
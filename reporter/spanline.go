@@ -0,0 +1,263 @@
+package reporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/internal/lineformat"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+const spanLogsTag = "_spanLogs"
+
+// ParseError describes malformed span data.
+type ParseError struct {
+	// Line is the number of the line in the input, starting at 1, or 0 when parsing a single line.
+	Line int
+
+	// Offset is the position in the line of the byte where the error was detected.
+	Offset int
+
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d, offset %d: %s", e.Line, e.Offset, e.Msg)
+	}
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Msg)
+}
+
+// ParseSpanLine parses a span in the Wavefront span data format, as produced by senders.SpanLine:
+//
+//	<operation> source=<source> traceId=<uuid> spanId=<uuid> [parent=<uuid>...] [followsFrom=<uuid>...] [<tag>=<value>...] <start_millis> <duration_millis>
+//
+// It returns the span and its source. Tags are parsed as strings, and the "component" tag also sets the
+// component of the span. The span logs sent along with a span are parsed separately with ParseSpanLogs.
+func ParseSpanLine(line string) (tracer.RawSpan, string, error) {
+	tokens, err := lineformat.Tokenize(line)
+	if err != nil {
+		syntaxErr := err.(*lineformat.SyntaxError)
+		return tracer.RawSpan{}, "", &ParseError{Offset: syntaxErr.Offset, Msg: syntaxErr.Msg}
+	}
+	if len(tokens) < 3 {
+		return tracer.RawSpan{}, "", &ParseError{Offset: len(line), Msg: "expected operation, tags, start and duration"}
+	}
+
+	name, start, duration := tokens[0], tokens[len(tokens)-2], tokens[len(tokens)-1]
+	if name.HasKey {
+		return tracer.RawSpan{}, "", &ParseError{Offset: name.Offset, Msg: "expected operation name, found tag " + strconv.Quote(name.Key)}
+	}
+	if name.Value == "" {
+		return tracer.RawSpan{}, "", &ParseError{Offset: name.Offset, Msg: "empty operation name"}
+	}
+	startMillis, err := parseSpanLineNumber(start, "start")
+	if err != nil {
+		return tracer.RawSpan{}, "", err
+	}
+	durationMillis, err := parseSpanLineNumber(duration, "duration")
+	if err != nil {
+		return tracer.RawSpan{}, "", err
+	}
+
+	span := tracer.RawSpan{
+		Operation: name.Value,
+		Start:     time.Unix(0, startMillis*int64(time.Millisecond)),
+		Duration:  time.Duration(durationMillis) * time.Millisecond,
+	}
+	var source string
+	var parents, followsFrom []string
+	for _, tok := range tokens[1 : len(tokens)-2] {
+		if !tok.HasKey {
+			return tracer.RawSpan{}, "", &ParseError{Offset: tok.Offset, Msg: fmt.Sprintf("expected tag, found %q", tok.Value)}
+		}
+		switch tok.Key {
+		case "source":
+			source = tok.Value
+		case "traceId", "spanId", "parent", "followsFrom":
+			if _, err := uuid.Parse(tok.Value); err != nil {
+				return tracer.RawSpan{}, "", &ParseError{Offset: tok.Offset, Msg: fmt.Sprintf("%s is not in UUID format: %q", tok.Key, tok.Value)}
+			}
+			switch tok.Key {
+			case "traceId":
+				span.Context.TraceID = tok.Value
+			case "spanId":
+				span.Context.SpanID = tok.Value
+			case "parent":
+				parents = append(parents, tok.Value)
+			case "followsFrom":
+				followsFrom = append(followsFrom, tok.Value)
+			}
+		case spanLogsTag:
+		default:
+			if tok.Key == "" {
+				return tracer.RawSpan{}, "", &ParseError{Offset: tok.Offset, Msg: "empty tag key"}
+			}
+			if span.Tags == nil {
+				span.Tags = opentracing.Tags{}
+			}
+			if _, found := span.Tags[tok.Key]; found {
+				if span.AdditionalTags == nil {
+					span.AdditionalTags = make(map[string][]interface{})
+				}
+				span.AdditionalTags[tok.Key] = append(span.AdditionalTags[tok.Key], tok.Value)
+			} else {
+				span.Tags[tok.Key] = tok.Value
+			}
+		}
+	}
+	if span.Context.TraceID == "" {
+		return tracer.RawSpan{}, "", &ParseError{Offset: len(line), Msg: "missing traceId"}
+	}
+	if span.Context.SpanID == "" {
+		return tracer.RawSpan{}, "", &ParseError{Offset: len(line), Msg: "missing spanId"}
+	}
+
+	for _, parent := range parents {
+		span.References = append(span.References, opentracing.SpanReference{
+			Type:              opentracing.ChildOfRef,
+			ReferencedContext: tracer.SpanContext{TraceID: span.Context.TraceID, SpanID: parent},
+		})
+	}
+	for _, item := range followsFrom {
+		span.References = append(span.References, opentracing.SpanReference{
+			Type:              opentracing.FollowsFromRef,
+			ReferencedContext: tracer.SpanContext{TraceID: span.Context.TraceID, SpanID: item},
+		})
+	}
+	if len(parents) > 0 {
+		span.ParentSpanID = parents[0]
+	}
+	if component, ok := span.Tags["component"].(string); ok {
+		span.Component = component
+	}
+	return span, source, nil
+}
+
+func parseSpanLineNumber(tok lineformat.Token, name string) (int64, error) {
+	if tok.HasKey {
+		return 0, &ParseError{Offset: tok.Offset, Msg: fmt.Sprintf("expected %s, found tag %q", name, tok.Key)}
+	}
+	n, err := strconv.ParseInt(tok.Value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, &ParseError{Offset: tok.Offset, Msg: fmt.Sprintf("invalid %s %q", name, tok.Value)}
+	}
+	return n, nil
+}
+
+// ParseSpanLogs parses the span logs of a span in the JSON format produced by senders.SpanLogJSON.
+// It returns the trace and span ids of the span along with its logs.
+func ParseSpanLogs(line string) (traceID, spanID string, logs []opentracing.LogRecord, err error) {
+	var spanLogs senders.SpanLogs
+	if err := json.Unmarshal([]byte(line), &spanLogs); err != nil {
+		offset := 0
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			offset = int(syntaxErr.Offset)
+		}
+		return "", "", nil, &ParseError{Offset: offset, Msg: "invalid span logs: " + err.Error()}
+	}
+	if spanLogs.TraceId == "" || spanLogs.SpanId == "" {
+		return "", "", nil, &ParseError{Msg: "span logs without traceId or spanId"}
+	}
+	for _, sl := range spanLogs.Logs {
+		lr := opentracing.LogRecord{Timestamp: time.Unix(0, sl.Timestamp*int64(time.Microsecond))}
+		for k, v := range sl.Fields {
+			lr.Fields = append(lr.Fields, log.String(k, v))
+		}
+		// the fields of span logs are not ordered
+		sort.Slice(lr.Fields, func(i, j int) bool { return lr.Fields[i].Key() < lr.Fields[j].Key() })
+		logs = append(logs, lr)
+	}
+	return spanLogs.TraceId, spanLogs.SpanId, logs, nil
+}
+
+// SpanLineReader reads spans from Wavefront span lines, one per line. A span logs line following a span
+// is attached to it when their trace and span ids match. Empty lines and lines starting with '#' are skipped.
+type SpanLineReader struct {
+	scanner  *bufio.Scanner
+	line     int
+	buffered *string // line read ahead while looking for span logs
+}
+
+// NewSpanLineReader returns a SpanLineReader reading from r.
+func NewSpanLineReader(r io.Reader) *SpanLineReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &SpanLineReader{scanner: scanner}
+}
+
+// Read returns the next span and its source, or io.EOF when there are no more spans.
+// Errors are of type *ParseError when the input is malformed.
+func (r *SpanLineReader) Read() (tracer.RawSpan, string, error) {
+	for {
+		line, ok := r.next()
+		if !ok {
+			if err := r.scanner.Err(); err != nil {
+				return tracer.RawSpan{}, "", err
+			}
+			return tracer.RawSpan{}, "", io.EOF
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "{") {
+			return tracer.RawSpan{}, "", &ParseError{Line: r.line, Msg: "span logs without a preceding span"}
+		}
+
+		span, source, err := ParseSpanLine(line)
+		if err != nil {
+			return tracer.RawSpan{}, "", r.lineError(err)
+		}
+
+		// attach the span logs following the span
+		next, ok := r.next()
+		if !ok {
+			return span, source, nil
+		}
+		if !strings.HasPrefix(strings.TrimSpace(next), "{") {
+			r.buffered = &next
+			return span, source, nil
+		}
+		traceID, spanID, logs, err := ParseSpanLogs(next)
+		if err != nil {
+			return tracer.RawSpan{}, "", r.lineError(err)
+		}
+		if traceID != span.Context.TraceID || spanID != span.Context.SpanID {
+			return tracer.RawSpan{}, "", &ParseError{Line: r.line, Msg: fmt.Sprintf("span logs of span %s do not match the preceding span %s", spanID, span.Context.SpanID)}
+		}
+		span.Logs = logs
+		return span, source, nil
+	}
+}
+
+func (r *SpanLineReader) next() (string, bool) {
+	if r.buffered != nil {
+		line := *r.buffered
+		r.buffered = nil
+		return line, true
+	}
+	if !r.scanner.Scan() {
+		return "", false
+	}
+	r.line++
+	return r.scanner.Text(), true
+}
+
+// lineError sets the line number of the error to the last line read.
+func (r *SpanLineReader) lineError(err error) error {
+	if parseErr, ok := err.(*ParseError); ok {
+		parseErr.Line = r.line
+	}
+	return err
+}
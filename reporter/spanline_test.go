@@ -0,0 +1,121 @@
+package reporter
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+func spanLines(t *testing.T, span tracer.RawSpan, source string) string {
+	parents, followsFrom := prepareReferences(span)
+	logs := prepareLogs(span, DefaultValueEncoder)
+	line, err := senders.SpanLine(span.Operation, span.Start.UnixNano()/1000000, span.Duration.Nanoseconds()/1000000, source,
+		span.Context.TraceID, span.Context.SpanID, parents, followsFrom, prepareTags(span, DefaultValueEncoder), logs, "")
+	require.NoError(t, err)
+	if len(logs) > 0 {
+		logsLine, err := senders.SpanLogJSON(span.Context.TraceID, span.Context.SpanID, logs)
+		require.NoError(t, err)
+		line += logsLine
+	}
+	return line
+}
+
+func TestParseSpanLine_RoundTrip(t *testing.T) {
+	parent := newSpan("parent")
+	linked := newSpan("linked")
+	span := newSpan("get user")
+	span.Context.TraceID = parent.Context.TraceID
+	span.Start = time.Unix(1533531013, 343000000)
+	span.References = []opentracing.SpanReference{
+		{Type: opentracing.ChildOfRef, ReferencedContext: parent.Context},
+		{Type: opentracing.FollowsFromRef, ReferencedContext: tracer.SpanContext{TraceID: parent.Context.TraceID, SpanID: linked.Context.SpanID}},
+	}
+	span.Tags = opentracing.Tags{"component": "test", "http.status_code": 200, "message": "say \"hi\"\nbye", "db.table": "users"}
+	span.AdditionalTags = map[string][]interface{}{"db.table": {"orders"}}
+
+	parsed, source, err := ParseSpanLine(spanLines(t, span, "host-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "host-1", source)
+	assert.Equal(t, span.Operation, parsed.Operation)
+	assert.Equal(t, span.Context.TraceID, parsed.Context.TraceID)
+	assert.Equal(t, span.Context.SpanID, parsed.Context.SpanID)
+	assert.Equal(t, parent.Context.SpanID, parsed.ParentSpanID)
+	assert.Equal(t, span.References[1], parsed.References[1])
+	assert.Equal(t, opentracing.Tags{"component": "test", "http.status_code": "200", "message": "say \"hi\"\nbye", "db.table": "users"}, parsed.Tags)
+	assert.Equal(t, span.AdditionalTags, parsed.AdditionalTags)
+	assert.Equal(t, "test", parsed.Component)
+	assert.True(t, span.Start.Equal(parsed.Start))
+	assert.Equal(t, span.Duration, parsed.Duration)
+}
+
+func TestParseSpanLine_Errors(t *testing.T) {
+	const (
+		traceID = "7b3bf470-9456-11e8-9eb6-529269fb1459"
+		spanID  = "0313bafe-9457-11e8-9eb6-529269fb1459"
+	)
+	ids := " traceId=" + traceID + " spanId=" + spanID
+	for _, tc := range []struct {
+		line string
+		at   string // the error offset is the position of at in the line, or the end of the line if empty
+		err  string
+	}{
+		{``, "", "expected operation, tags, start and duration"},
+		{`op source=h` + ids + ` 1 2 3`, "1 2 3", `expected tag, found "1"`},
+		{`"op source=h 1 2`, `"op`, "unterminated quoted string"},
+		{`op source=h traceId=abc spanId=` + spanID + ` 1 2`, "traceId", `traceId is not in UUID format: "abc"`},
+		{`op source=h spanId=` + spanID + ` 1 2`, "", "missing traceId"},
+		{`op source=h` + ids + ` 1 x`, "x", `invalid duration "x"`},
+		{`op source=h` + ids + ` "k"="v"x 1 2`, "x", `unexpected character 'x'`},
+		{`op source=h` + ids + ` k=v"x 1 2`, `"x`, `unexpected quote`},
+	} {
+		offset := len(tc.line)
+		if tc.at != "" {
+			offset = strings.Index(tc.line, tc.at)
+		}
+		_, _, err := ParseSpanLine(tc.line)
+		if assert.Error(t, err, tc.line) {
+			assert.Equal(t, &ParseError{Offset: offset, Msg: tc.err}, err, tc.line)
+		}
+	}
+}
+
+func TestSpanLineReader(t *testing.T) {
+	first := newSpan("first")
+	first.Logs = []opentracing.LogRecord{{
+		Timestamp: time.Unix(1533531013, 343000000),
+		Fields:    []log.Field{log.String("event", "retry"), log.Int("attempt", 2)},
+	}}
+	second := newSpan("second")
+
+	input := "# captured\n" + spanLines(t, first, "h") + "\n" + spanLines(t, second, "h")
+	r := NewSpanLineReader(strings.NewReader(input))
+
+	span, _, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "first", span.Operation)
+	require.Len(t, span.Logs, 1)
+	assert.True(t, first.Logs[0].Timestamp.Equal(span.Logs[0].Timestamp))
+	assert.Equal(t, []log.Field{log.String("attempt", "2"), log.String("event", "retry")}, span.Logs[0].Fields)
+
+	span, _, err = r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "second", span.Operation)
+	assert.Empty(t, span.Logs)
+
+	_, _, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+
+	r = NewSpanLineReader(strings.NewReader(spanLines(t, second, "h") + "op 1 2\n"))
+	_, _, err = r.Read()
+	require.NoError(t, err)
+	_, _, err = r.Read()
+	assert.EqualError(t, err, "line 2, offset 6: missing traceId")
+}
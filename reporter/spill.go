@@ -0,0 +1,339 @@
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+const (
+	spillSuffix             = ".spill"
+	defaultSpillSegmentSize = 4 * 1024 * 1024
+	minSpillBackoff         = time.Second
+	maxSpillBackoff         = time.Minute
+	spillBufferSize         = 10000 // dropped spans waiting to be spilled
+)
+
+var errSpillClosed = errors.New("spill queue closed")
+
+// spanRecord holds a span in the form it is handed to the sender.
+type spanRecord struct {
+	Name           string            `json:"name"`
+	StartMillis    int64             `json:"start"`
+	DurationMillis int64             `json:"duration"`
+	Source         string            `json:"source"`
+	TraceID        string            `json:"traceId"`
+	SpanID         string            `json:"spanId"`
+	Parents        []string          `json:"parents,omitempty"`
+	FollowsFrom    []string          `json:"followsFrom,omitempty"`
+	Tags           []senders.SpanTag `json:"tags,omitempty"`
+	Logs           []senders.SpanLog `json:"logs,omitempty"`
+}
+
+type segment struct {
+	seq   uint64
+	size  int64
+	count int
+}
+
+// spillQueue is a size capped write-ahead queue of span records stored as JSON lines in segment files.
+// Records are replayed at least once: a segment is only removed once all of its records were sent,
+// so records of a partially replayed segment are sent again after a restart.
+type spillQueue struct {
+	mtx          sync.Mutex
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	size         int64
+	sealed       []*segment // oldest first
+	active       *segment
+	file         *os.File
+	nextSeq      uint64
+	pending      [][]byte // unread records of sealed[0], once loaded
+	loaded       bool
+	closed       bool
+
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// openSpillQueue opens the queue stored in dir, creating the directory if needed.
+// Segments left over by a previous process are queued for replay.
+func openSpillQueue(dir string, maxBytes, segmentBytes int64) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &spillQueue{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		notify:       make(chan struct{}, 1),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, spillSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spillSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		q.sealed = append(q.sealed, &segment{seq: seq, size: int64(len(data)), count: bytes.Count(data, []byte("\n"))})
+		q.size += int64(len(data))
+		if seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(q.sealed, func(i, j int) bool { return q.sealed[i].seq < q.sealed[j].seq })
+	return q, nil
+}
+
+func (q *spillQueue) path(seg *segment) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seg.seq, spillSuffix))
+}
+
+// append writes the record to the active segment. It returns the number of previously
+// spilled records that were discarded to stay within the size cap.
+func (q *spillQueue) append(rec spanRecord) (int, error) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	line = append(line, '\n')
+	n := int64(len(line))
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if q.closed {
+		return 0, errSpillClosed
+	}
+
+	discarded := 0
+	for q.size+n > q.maxBytes && len(q.sealed) > 0 {
+		discarded += q.removeOldest()
+	}
+	if q.size+n > q.maxBytes {
+		return discarded, fmt.Errorf("spill queue full")
+	}
+
+	if q.active != nil && q.active.size+n > q.segmentBytes {
+		q.seal()
+	}
+	if q.active == nil {
+		seg := &segment{seq: q.nextSeq}
+		file, err := os.OpenFile(q.path(seg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return discarded, err
+		}
+		q.nextSeq++
+		q.active, q.file = seg, file
+	}
+	if _, err := q.file.Write(line); err != nil {
+		return discarded, err
+	}
+	q.active.size += n
+	q.active.count++
+	q.size += n
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return discarded, nil
+}
+
+// seal closes the active segment and queues it for replay.
+func (q *spillQueue) seal() {
+	if q.active == nil {
+		return
+	}
+	q.file.Close()
+	q.sealed = append(q.sealed, q.active)
+	q.active, q.file = nil, nil
+}
+
+// removeOldest deletes the oldest sealed segment and returns the number of unsent records it held.
+func (q *spillQueue) removeOldest() int {
+	seg := q.sealed[0]
+	count := seg.count
+	if q.loaded {
+		count = len(q.pending)
+	}
+	q.sealed = q.sealed[1:]
+	q.pending, q.loaded = nil, false
+	q.size -= seg.size
+	if err := os.Remove(q.path(seg)); err != nil && !os.IsNotExist(err) {
+		log.Printf("error removing spill segment: %v", err)
+	}
+	return count
+}
+
+// next returns the oldest spilled record without removing it from the queue.
+func (q *spillQueue) next() (spanRecord, bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for {
+		if !q.loaded {
+			if len(q.sealed) == 0 {
+				if q.active == nil || q.active.count == 0 {
+					return spanRecord{}, false
+				}
+				q.seal()
+			}
+			data, err := ioutil.ReadFile(q.path(q.sealed[0]))
+			if err != nil {
+				log.Printf("error reading spill segment: %v", err)
+				q.removeOldest()
+				continue
+			}
+			q.pending = bytes.SplitAfter(data, []byte("\n"))
+			q.loaded = true
+		}
+
+		for len(q.pending) > 0 {
+			var rec spanRecord
+			if err := json.Unmarshal(q.pending[0], &rec); err == nil {
+				return rec, true
+			}
+			// skip records truncated by a crash while writing
+			q.pending = q.pending[1:]
+		}
+		q.removeOldest()
+	}
+}
+
+// commit removes the record returned by the last call to next.
+func (q *spillQueue) commit() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if !q.loaded || len(q.pending) == 0 {
+		return
+	}
+	q.pending = q.pending[1:]
+	if len(q.pending) == 0 {
+		q.removeOldest()
+	}
+}
+
+func (q *spillQueue) bytes() int64 {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return q.size
+}
+
+// close stops the replay loop and closes the active segment. Unsent records stay on disk, and records
+// appended after closing are rejected. A replay send in progress is waited for until the context is done,
+// and then no longer than the grace period, since the sender may block; ctx.Err() is returned if it did not
+// complete.
+func (q *spillQueue) close(ctx context.Context) error {
+	close(q.stop)
+	var err error
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		select {
+		case <-q.done:
+		case <-time.After(shutdownGracePeriod):
+			err = ctx.Err()
+		}
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.closed = true
+	if q.file != nil {
+		q.file.Close()
+	}
+	return err
+}
+
+// spill stores the span record on disk for later replay.
+func (t *reporter) spill(rec spanRecord) bool {
+	discarded, err := t.spillQueue.append(rec)
+	t.spillDropped.Inc(int64(discarded))
+	if err != nil {
+		t.spillDropped.Inc(1)
+		if t.loggingAllowed() {
+			log.Printf("error spilling span: %s error: %v", rec.Name, err)
+		}
+		return false
+	}
+	t.spansSpilled.Inc(1)
+	return true
+}
+
+// spillDroppedSpans spills the spans dropped from the in-memory buffer, counting the ones that could not be spilled.
+func (t *reporter) spillDroppedSpans() {
+	defer close(t.spillDone)
+	for dropped := range t.spillCh {
+		if !t.spill(t.prepareSpan(dropped.span)) {
+			t.countDropped(dropped.counter, dropped.msg, dropped.span)
+		}
+	}
+}
+
+// replay sends spilled spans, backing off while the sender keeps failing.
+func (t *reporter) replay() {
+	q := t.spillQueue
+	defer close(q.done)
+
+	backoff := minSpillBackoff
+	for {
+		rec, ok := q.next()
+		if !ok {
+			select {
+			case <-q.notify:
+				continue
+			case <-q.stop:
+				return
+			}
+		}
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		if err := t.send(rec); err != nil {
+			select {
+			case <-time.After(backoff):
+			case <-q.stop:
+				return
+			}
+			if backoff *= 2; backoff > maxSpillBackoff {
+				backoff = maxSpillBackoff
+			}
+			continue
+		}
+		q.commit()
+		t.spansReplayed.Inc(1)
+		backoff = minSpillBackoff
+	}
+}
@@ -0,0 +1,109 @@
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempSpillDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func drainSpillQueue(q *spillQueue) []string {
+	var names []string
+	for {
+		rec, ok := q.next()
+		if !ok {
+			return names
+		}
+		names = append(names, rec.Name)
+		q.commit()
+	}
+}
+
+func TestSpillQueue_ReplayInOrder(t *testing.T) {
+	q, err := openSpillQueue(tempSpillDir(t), 1<<20, 256)
+	require.NoError(t, err)
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("span-%d", i)
+		want = append(want, name)
+		_, err := q.append(spanRecord{Name: name})
+		require.NoError(t, err)
+	}
+	assert.True(t, len(q.sealed) > 1, "records should span several segments")
+	assert.Equal(t, want, drainSpillQueue(q))
+	assert.Equal(t, int64(0), q.bytes())
+}
+
+func TestSpillQueue_SizeCap(t *testing.T) {
+	q, err := openSpillQueue(tempSpillDir(t), 300, 100)
+	require.NoError(t, err)
+
+	discarded := 0
+	for i := 0; i < 10; i++ {
+		n, err := q.append(spanRecord{Name: fmt.Sprintf("span-%d", i)})
+		require.NoError(t, err)
+		discarded += n
+	}
+	assert.True(t, q.bytes() <= 300)
+	names := drainSpillQueue(q)
+	assert.Equal(t, 10, discarded+len(names))
+	assert.Equal(t, "span-9", names[len(names)-1])
+}
+
+func TestSpillQueue_SurvivesRestart(t *testing.T) {
+	dir := tempSpillDir(t)
+	q, err := openSpillQueue(dir, 1<<20, 1<<10)
+	require.NoError(t, err)
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := q.append(spanRecord{Name: name})
+		require.NoError(t, err)
+	}
+	q.file.Close()
+
+	q, err = openSpillQueue(dir, 1<<20, 1<<10)
+	require.NoError(t, err)
+	_, err = q.append(spanRecord{Name: "d"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, drainSpillQueue(q))
+}
+
+func TestReporter_SpillsFailedSends(t *testing.T) {
+	sender := &testSender{failing: true}
+	q, err := openSpillQueue(tempSpillDir(t), 1<<20, 1<<10)
+	require.NoError(t, err)
+	r := &reporter{
+		sender:        sender,
+		spillQueue:    q,
+		errorsCount:   metrics.NewCounter(),
+		spansSpilled:  metrics.NewCounter(),
+		spansReplayed: metrics.NewCounter(),
+		spillDropped:  metrics.NewCounter(),
+	}
+
+	r.reportInternal(newSpan("a"))
+	r.reportInternal(newSpan("b"))
+	assert.Equal(t, int64(2), r.errorsCount.Count())
+	assert.Equal(t, int64(2), r.spansSpilled.Count())
+
+	sender.setFailing(false)
+	go r.replay()
+	defer q.close(context.Background())
+	assert.Eventually(t, func() bool {
+		return r.spansReplayed.Count() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a", "b"}, sender.spanNames())
+}
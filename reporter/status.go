@@ -0,0 +1,150 @@
+package reporter
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const defaultUnhealthyAfter = time.Minute
+
+// Status is a snapshot of the state of a WavefrontSpanReporter.
+type Status struct {
+	// Healthy is false once the reporter is closed, or when sending has failed for longer than allowed.
+	// See UnhealthyAfter.
+	Healthy bool `json:"healthy"`
+	Closed  bool `json:"closed"`
+
+	QueueSize         int   `json:"queueSize"`
+	RemainingCapacity int   `json:"remainingCapacity"`
+	SpillSizeBytes    int64 `json:"spillSizeBytes"`
+
+	// The counts since the reporter was created.
+	SpansReceived  int64 `json:"spansReceived"`
+	SpansDropped   int64 `json:"spansDropped"`
+	SpansDiscarded int64 `json:"spansDiscarded"`
+	Errors         int64 `json:"errors"`
+
+	// LastError is the last error sending a span, at LastErrorTime. Empty if no send failed.
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime"`
+
+	// LastSuccessTime is the time of the last span sent successfully.
+	LastSuccessTime time.Time `json:"lastSuccessTime"`
+
+	// FailingSince is the time of the first failure since the last span sent successfully. Zero if the last
+	// send succeeded.
+	FailingSince time.Time `json:"failingSince"`
+}
+
+// UnhealthyAfter sets how long sending spans can fail before the reporter is not healthy. Defaults to 1 minute.
+func UnhealthyAfter(d time.Duration) Option {
+	return func(args *reporter) {
+		args.unhealthyAfter = d
+	}
+}
+
+// recordSend records the outcome of sending a span, for the status of the reporter.
+func (t *reporter) recordSend(err error) {
+	now := time.Now()
+	t.healthMtx.Lock()
+	defer t.healthMtx.Unlock()
+	if err == nil {
+		t.lastSuccessTime = now
+		t.failingSince = time.Time{}
+		return
+	}
+	t.lastError, t.lastErrorTime = err, now
+	if t.failingSince.IsZero() {
+		t.failingSince = now
+	}
+}
+
+// Status complies with the WavefrontSpanReporter interface.
+func (t *reporter) Status() Status {
+	status := Status{
+		Closed:            t.isClosed(),
+		QueueSize:         len(t.spansCh),
+		RemainingCapacity: t.bufferSize - len(t.spansCh),
+		SpansReceived:     total(t.spansReceived),
+		SpansDropped:      total(t.spansDropped),
+		SpansDiscarded:    total(t.spansDiscarded),
+		Errors:            total(t.errorsCount),
+	}
+	if t.spillQueue != nil {
+		status.SpillSizeBytes = t.spillQueue.bytes()
+	}
+
+	t.healthMtx.Lock()
+	if t.lastError != nil {
+		status.LastError = t.lastError.Error()
+	}
+	status.LastErrorTime = t.lastErrorTime
+	status.LastSuccessTime = t.lastSuccessTime
+	status.FailingSince = t.failingSince
+	t.healthMtx.Unlock()
+
+	status.Healthy = !status.Closed && (status.FailingSince.IsZero() || time.Since(status.FailingSince) < t.unhealthyAfter)
+	return status
+}
+
+// Healthy complies with the WavefrontSpanReporter interface.
+func (t *reporter) Healthy() bool {
+	return t.Status().Healthy
+}
+
+// NewStatusHandler returns an http.Handler serving the status of the reporter in JSON. It responds with
+// 503 Service Unavailable when the reporter is not healthy, so it can back a Kubernetes readiness probe.
+func NewStatusHandler(r WavefrontSpanReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status := r.Status()
+		body, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(append(body, '\n'))
+	})
+}
+
+// PublishExpvar publishes the status of the reporter as an expvar variable with the given name, served
+// by the expvar handler at /debug/vars. Like expvar.Publish, it panics if the name is already in use.
+func PublishExpvar(name string, r WavefrontSpanReporter) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return r.Status()
+	}))
+}
+
+// cumulativeCounter is a metrics.Counter that also keeps the total of the increments, since delta counters
+// are decremented when they are reported.
+type cumulativeCounter struct {
+	metrics.Counter
+	total int64
+}
+
+func (c *cumulativeCounter) Inc(i int64) {
+	c.Counter.Inc(i)
+	atomic.AddInt64(&c.total, i)
+}
+
+// Total returns the total of the increments.
+func (c *cumulativeCounter) Total() int64 {
+	return atomic.LoadInt64(&c.total)
+}
+
+// total returns the total of the increments of the counter, or its count if it is not a cumulativeCounter,
+// for example when it was registered beforehand in the registry given to InternalMetricsRegistry.
+func total(c metrics.Counter) int64 {
+	if cc, ok := c.(*cumulativeCounter); ok {
+		return cc.Total()
+	}
+	return c.Count()
+}
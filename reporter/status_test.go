@@ -0,0 +1,103 @@
+package reporter
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+func TestReporter_Status(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"), BufferSize(100), InternalMetricsInterval(time.Millisecond))
+	defer r.Close()
+
+	unsampled := newSpan("unsampled")
+	decision := false
+	unsampled.Context.Sampled = &decision
+	r.ReportSpan(newSpan("sent"))
+	r.ReportSpan(unsampled)
+	require.NoError(t, r.Flush(context.Background()))
+
+	status := r.Status()
+	assert.True(t, status.Healthy)
+	assert.False(t, status.Closed)
+	assert.Equal(t, 0, status.QueueSize)
+	assert.Equal(t, 100, status.RemainingCapacity)
+	assert.Equal(t, int64(1), status.SpansReceived, "the counts are not reset when the metrics are reported")
+	assert.Equal(t, int64(1), status.SpansDiscarded)
+	assert.Empty(t, status.LastError)
+	assert.False(t, status.LastSuccessTime.IsZero())
+	assert.True(t, status.FailingSince.IsZero())
+
+	sender.setFailing(true)
+	r.ReportSpan(newSpan("failed"))
+	assert.Error(t, r.Flush(context.Background()))
+
+	status = r.Status()
+	assert.Equal(t, "sender unavailable", status.LastError)
+	assert.False(t, status.LastErrorTime.IsZero())
+	assert.Equal(t, status.LastErrorTime, status.FailingSince)
+	assert.Equal(t, int64(1), status.Errors)
+	assert.True(t, status.Healthy, "failing for less than a minute")
+}
+
+func TestReporter_Healthy(t *testing.T) {
+	sender := &testSender{failing: true}
+	r := New(sender, application.New("app", "service"), UnhealthyAfter(20*time.Millisecond))
+
+	r.ReportSpan(newSpan("failed"))
+	assert.Error(t, r.Flush(context.Background()))
+	assert.True(t, r.Healthy())
+	assert.Eventually(t, func() bool { return !r.Healthy() }, 5*time.Second, 5*time.Millisecond)
+
+	sender.setFailing(false)
+	r.ReportSpan(newSpan("sent"))
+	require.NoError(t, r.Flush(context.Background()))
+	assert.True(t, r.Healthy())
+
+	require.NoError(t, r.Close())
+	assert.False(t, r.Healthy())
+	assert.True(t, r.Status().Closed)
+}
+
+func TestStatusHandler(t *testing.T) {
+	sender := &testSender{}
+	r := New(sender, application.New("app", "service"))
+	r.ReportSpan(newSpan("sent"))
+	require.NoError(t, r.Flush(context.Background()))
+
+	rec := httptest.NewRecorder()
+	NewStatusHandler(r).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var status map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, true, status["healthy"])
+	assert.Equal(t, float64(1), status["spansReceived"])
+
+	require.NoError(t, r.Close())
+	rec = httptest.NewRecorder()
+	NewStatusHandler(r).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestPublishExpvar(t *testing.T) {
+	r := New(&testSender{}, application.New("app", "service"))
+	defer r.Close()
+	name := fmt.Sprintf("wavefront_reporter_%d", time.Now().UnixNano()) // expvar names cannot be reused
+	PublishExpvar(name, r)
+
+	var status Status
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &status))
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 50000, status.RemainingCapacity)
+}
@@ -0,0 +1,153 @@
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+const zipkinSpansPath = "/api/v2/spans"
+
+// zipkinSpan is a span in the Zipkin v2 JSON format.
+type zipkinSpan struct {
+	TraceID       string             `json:"traceId"`
+	ID            string             `json:"id"`
+	ParentID      string             `json:"parentId,omitempty"`
+	Name          string             `json:"name"`
+	Kind          string             `json:"kind,omitempty"`
+	Timestamp     int64              `json:"timestamp"`
+	Duration      int64              `json:"duration"`
+	Debug         bool               `json:"debug,omitempty"`
+	LocalEndpoint zipkinEndpoint     `json:"localEndpoint"`
+	Annotations   []zipkinAnnotation `json:"annotations,omitempty"`
+	Tags          map[string]string  `json:"tags,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+type zipkinReporter struct {
+	*batcher
+	url         string
+	application application.Tags
+}
+
+// NewZipkinSpanReporter returns a SpanReporter exporting spans in batches to the Zipkin server at the given
+// base URL, for example "http://localhost:9411", using the Zipkin v2 JSON format.
+// The service of a span is taken from its "service" tag, and defaults to the service of the application tags.
+func NewZipkinSpanReporter(url string, app application.Tags, options ...ExporterOption) tracer.SpanReporter {
+	r := &zipkinReporter{
+		url:         strings.TrimSuffix(url, "/") + zipkinSpansPath,
+		application: app,
+	}
+	r.batcher = newBatcher("zipkin reporter", newExporterConfig(options), r.export)
+	return r
+}
+
+func (r *zipkinReporter) export(spans []tracer.RawSpan) error {
+	zipkinSpans := make([]zipkinSpan, 0, len(spans))
+	for _, span := range spans {
+		zs, err := r.zipkinSpan(span)
+		if err != nil {
+			logSkippedSpan(span, err)
+			continue
+		}
+		zipkinSpans = append(zipkinSpans, zs)
+	}
+	body, err := json.Marshal(zipkinSpans)
+	if err != nil {
+		return err
+	}
+	return r.batcher.cfg.post(r.url, "application/json", body)
+}
+
+func (r *zipkinReporter) zipkinSpan(span tracer.RawSpan) (zipkinSpan, error) {
+	traceID, err := hexID(span.Context.TraceID, 16)
+	if err != nil {
+		return zipkinSpan{}, fmt.Errorf("invalid trace id: %v", err)
+	}
+	id, err := hexID(span.Context.SpanID, 8)
+	if err != nil {
+		return zipkinSpan{}, fmt.Errorf("invalid span id: %v", err)
+	}
+
+	zs := zipkinSpan{
+		// use 64 bit trace ids where possible, as some Zipkin instrumentation does
+		TraceID:   strings.TrimPrefix(traceID, "0000000000000000"),
+		ID:        id,
+		Name:      span.Operation,
+		Kind:      zipkinKind(span.Tags),
+		Timestamp: span.Start.UnixNano() / 1000,
+		Duration:  span.Duration.Nanoseconds() / 1000,
+		Debug:     hasTrueTag("debug", span.Tags),
+		Tags:      make(map[string]string, len(span.Tags)),
+	}
+	// Zipkin spans have a single parent, the first ChildOf reference
+	if i := parentIndex(span); i >= 0 {
+		if refCtx, ok := span.References[i].ReferencedContext.(tracer.SpanContext); ok {
+			zs.ParentID, _ = hexID(refCtx.SpanID, 8)
+		}
+	}
+	zs.LocalEndpoint.ServiceName, _ = getAppTag("service", r.application.Service, span.Tags, r.cfg.encoder)
+
+	for k, v := range r.application.Map() {
+		if k != "service" && v != "" {
+			zs.Tags[k] = v
+		}
+	}
+	for k, v := range span.Tags {
+		if k == string(ext.SpanKind) || k == "service" || k == "debug" {
+			continue
+		}
+		values := []string{r.cfg.encoder(v)}
+		for _, additional := range span.AdditionalTags[k] {
+			values = append(values, r.cfg.encoder(additional))
+		}
+		// Zipkin tags have a single value, so the values of the tags with several values are joined
+		zs.Tags[k] = strings.Join(values, ",")
+	}
+	if _, found := zs.Tags["component"]; !found && span.Component != "" {
+		zs.Tags["component"] = span.Component
+	}
+
+	for _, lr := range span.Logs {
+		zs.Annotations = append(zs.Annotations, zipkinAnnotation{
+			Timestamp: lr.Timestamp.UnixNano() / 1000,
+			Value:     zipkinAnnotationValue(lr, r.cfg.encoder),
+		})
+	}
+	return zs, nil
+}
+
+func zipkinKind(tags opentracing.Tags) string {
+	kind, _ := getAppTag(string(ext.SpanKind), "", tags, DefaultValueEncoder)
+	switch kind {
+	case string(ext.SpanKindRPCServerEnum), string(ext.SpanKindRPCClientEnum),
+		string(ext.SpanKindProducerEnum), string(ext.SpanKindConsumerEnum):
+		return strings.ToUpper(kind)
+	}
+	return ""
+}
+
+// zipkinAnnotationValue returns the event of a log with a single event field, or its key=value fields otherwise.
+func zipkinAnnotationValue(lr opentracing.LogRecord, encode ValueEncoder) string {
+	if len(lr.Fields) == 1 && lr.Fields[0].Key() == "event" {
+		return encode(lr.Fields[0].Value())
+	}
+	fields := make([]string, len(lr.Fields))
+	for i, field := range lr.Fields {
+		fields[i] = field.Key() + "=" + encode(field.Value())
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,125 @@
+package reporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+)
+
+func TestZipkinSpanReporter(t *testing.T) {
+	var batches [][]zipkinSpan
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, zipkinSpansPath, r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var spans []zipkinSpan
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&spans))
+		batches = append(batches, spans)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	app := application.New("app", "svc")
+	r := NewZipkinSpanReporter(server.URL+"/", app, ExporterBatchSize(2))
+
+	parent := newSpan("parent")
+	parent.Context.TraceID = "00000000-0000-0000-1234-567890abcdef"
+	child := newSpan("child")
+	child.Context.TraceID = parent.Context.TraceID
+	child.References = []opentracing.SpanReference{{Type: opentracing.ChildOfRef, ReferencedContext: parent.Context}}
+	child.Tags = opentracing.Tags{"span.kind": "client", "service": "other", "http.status_code": 200}
+	child.Logs = []opentracing.LogRecord{
+		{Timestamp: time.Now(), Fields: []log.Field{log.String("event", "cache miss")}},
+		{Timestamp: time.Now(), Fields: []log.Field{log.String("key", "user"), log.Int("size", 3)}},
+	}
+	other := newSpan("other")
+
+	r.ReportSpan(parent)
+	r.ReportSpan(child)
+	r.ReportSpan(other)
+	require.NoError(t, r.(*zipkinReporter).Flush(context.Background()))
+	require.NoError(t, r.Close())
+
+	require.Len(t, batches, 2)
+	require.Len(t, batches[0], 2)
+	zp, zc := batches[0][0], batches[0][1]
+	assert.Equal(t, "1234567890abcdef", zp.TraceID)
+	assert.Equal(t, zp.TraceID, zc.TraceID)
+	assert.Len(t, zc.ID, 16)
+	assert.Equal(t, zp.ID, zc.ParentID)
+	assert.Equal(t, "CLIENT", zc.Kind)
+	assert.Equal(t, "", zp.Kind)
+	assert.Equal(t, "svc", zp.LocalEndpoint.ServiceName)
+	assert.Equal(t, "other", zc.LocalEndpoint.ServiceName)
+	assert.Equal(t, "200", zc.Tags["http.status_code"])
+	assert.Equal(t, "app", zc.Tags["application"])
+	assert.NotContains(t, zc.Tags, "span.kind")
+	assert.Equal(t, int64(1000), zc.Duration)
+	assert.Equal(t, "cache miss", zc.Annotations[0].Value)
+	assert.Equal(t, "key=user size=3", zc.Annotations[1].Value)
+
+	require.Len(t, batches[1], 1)
+	assert.Len(t, batches[1][0].TraceID, 32)
+	assert.True(t, strings.HasPrefix(strings.Replace(other.Context.TraceID, "-", "", -1), batches[1][0].TraceID))
+}
+
+func TestZipkinSpan_Parent(t *testing.T) {
+	r := &zipkinReporter{batcher: &batcher{cfg: newExporterConfig(nil)}, application: application.New("app", "svc")}
+	previous, parent := newSpan("previous"), newSpan("parent")
+	span := newSpan("span")
+	span.References = []opentracing.SpanReference{
+		{Type: opentracing.FollowsFromRef, ReferencedContext: previous.Context},
+		{Type: opentracing.ChildOfRef, ReferencedContext: parent.Context},
+	}
+	zs, err := r.zipkinSpan(span)
+	require.NoError(t, err)
+	parentID, _ := hexID(parent.Context.SpanID, 8)
+	assert.Equal(t, parentID, zs.ParentID)
+
+	span.References = span.References[:1]
+	zs, err = r.zipkinSpan(span)
+	require.NoError(t, err)
+	assert.Equal(t, "", zs.ParentID, "a FollowsFrom reference is not a parent")
+}
+
+func TestZipkinSpan_ValueEncoding(t *testing.T) {
+	encoder := func(value interface{}) string { return strings.ToUpper(DefaultValueEncoder(value)) }
+	r := &zipkinReporter{
+		batcher:     &batcher{cfg: newExporterConfig([]ExporterOption{ExporterValueEncoding(encoder)})},
+		application: application.New("app", "svc"),
+	}
+	zs, err := r.zipkinSpan(spanWithTags("op", opentracing.Tags{"service": "other", "db.table": "users"}))
+	require.NoError(t, err)
+	assert.Equal(t, "OTHER", zs.LocalEndpoint.ServiceName, "the service tag is encoded like the other tags")
+	assert.Equal(t, "USERS", zs.Tags["db.table"])
+}
+
+func TestZipkinSpanReporter_AddTag(t *testing.T) {
+	var spans []zipkinSpan
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&spans))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	r := NewZipkinSpanReporter(server.URL, application.New("app", "svc"))
+	span := tracer.New(r).StartSpan("op", opentracing.Tag{Key: "db.table", Value: "users"})
+	tracer.AddTag(span, "db.table", "orders")
+	tracer.AddTag(span, "retries", 2)
+	span.Finish()
+	require.NoError(t, r.Close())
+
+	require.Len(t, spans, 1)
+	assert.Equal(t, "users,orders", spans[0].Tags["db.table"], "the values are joined")
+	assert.Equal(t, "2", spans[0].Tags["retries"])
+}
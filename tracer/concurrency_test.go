@@ -2,9 +2,13 @@ package tracer
 
 import (
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
 )
 
 const op = "test"
@@ -34,3 +38,73 @@ func TestConcurrentUsage(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestConcurrentFinish(t *testing.T) {
+	var cr CountingReporter
+	registry := testMetricsRegistry{metrics.NewRegistry()}
+	tracer := New(&cr, WithMetricsRegistry(registry))
+	const num = 100
+	for i := 0; i < num; i++ {
+		sp := tracer.StartSpan(op)
+		var wg sync.WaitGroup
+		wg.Add(4)
+		for j := 0; j < 2; j++ {
+			go func() {
+				defer wg.Done()
+				sp.Finish()
+			}()
+		}
+		go func() {
+			defer wg.Done()
+			sp.SetTag("foo", "bar")
+			sp.LogFields(log.String("event", "test"))
+			sp.SetOperationName("x")
+			sp.SetBaggageItem("boo", "far")
+		}()
+		go func() {
+			defer wg.Done()
+			sp.Context()
+			sp.BaggageItem("boo")
+		}()
+		wg.Wait()
+	}
+
+	assert.Equal(t, int32(num), atomic.LoadInt32((*int32)(&cr)), "each span is reported once")
+	assert.Equal(t, int64(num), registry.count("spans.finished"))
+	assert.Equal(t, int64(num), registry.count("spans.finished.again"))
+}
+
+func TestModifiedAfterFinish(t *testing.T) {
+	reporter := NewInMemoryReporter()
+	registry := testMetricsRegistry{metrics.NewRegistry()}
+	tracer := New(reporter, WithMetricsRegistry(registry))
+	sp := tracer.StartSpan(op, opentracing.Tag{Key: "foo", Value: "bar"})
+	sp.Finish()
+
+	sp.SetTag("foo", "baz")
+	sp.SetOperationName("x")
+	sp.LogKV("event", "test")
+	sp.LogEvent("test")
+	sp.SetBaggageItem("boo", "far")
+	sp.FinishWithOptions(opentracing.FinishOptions{LogRecords: []opentracing.LogRecord{{}}})
+
+	spans := reporter.getSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, op, spans[0].Operation)
+	assert.Equal(t, "bar", spans[0].Tags["foo"])
+	assert.Empty(t, spans[0].Logs)
+	assert.Empty(t, sp.BaggageItem("boo"))
+	assert.Equal(t, int64(5), registry.count("spans.modified.after_finish"))
+	assert.Equal(t, int64(1), registry.count("spans.finished.again"))
+}
+
+func TestStrictSpanLifecycle(t *testing.T) {
+	tracer := New(NewInMemoryReporter(), WithStrictSpanLifecycle())
+	sp := tracer.StartSpan(op)
+	sp.SetTag("foo", "bar")
+	sp.Finish()
+
+	assert.PanicsWithValue(t, `SetTag called on finished span "test"`, func() { sp.SetTag("foo", "baz") })
+	assert.PanicsWithValue(t, `Finish called on finished span "test"`, sp.Finish)
+	assert.NotPanics(t, func() { sp.Context() }, "finished spans can still be read")
+}
@@ -0,0 +1,66 @@
+package tracer
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// RecordErrorOption allows customizing RecordError.
+type RecordErrorOption func(*recordErrorConfig)
+
+type recordErrorConfig struct {
+	kind  string
+	stack bool
+}
+
+// WithErrorKind sets the "error.kind" field. Defaults to the type of the error, such as "*net.OpError".
+func WithErrorKind(kind string) RecordErrorOption {
+	return func(cfg *recordErrorConfig) {
+		cfg.kind = kind
+	}
+}
+
+// WithStackTrace adds the stack where RecordError is called in the "stack" field.
+func WithStackTrace() RecordErrorOption {
+	return func(cfg *recordErrorConfig) {
+		cfg.stack = true
+	}
+}
+
+// RecordError marks the span as failed with the "error=true" tag, so it is sampled when finished, and logs the
+// error following the OpenTracing conventions: "event=error", "error.kind", "error.object" and "message" fields,
+// along with the optional "stack" field. The errors it wraps, as returned by errors.Unwrap, are logged in the
+// "error.cause.<n>" and "error.cause.<n>.kind" fields, starting at 1. A nil error is ignored.
+func RecordError(span opentracing.Span, err error, options ...RecordErrorOption) {
+	if err == nil {
+		return
+	}
+	cfg := recordErrorConfig{kind: fmt.Sprintf("%T", err)}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	fields := []log.Field{
+		log.String("event", "error"),
+		log.String("error.kind", cfg.kind),
+		log.Error(err),
+		log.String("message", err.Error()),
+	}
+	if cfg.stack {
+		stack := make([]uintptr, maxStackDepth)
+		stack = stack[:runtime.Callers(2, stack)]
+		fields = append(fields, log.String("stack", formatStack(stack)))
+	}
+	for i, cause := 1, errors.Unwrap(err); cause != nil; i, cause = i+1, errors.Unwrap(cause) {
+		key := fmt.Sprintf("error.cause.%d", i)
+		fields = append(fields, log.String(key, cause.Error()), log.String(key+".kind", fmt.Sprintf("%T", cause)))
+	}
+
+	ext.Error.Set(span, true)
+	span.LogFields(fields...)
+}
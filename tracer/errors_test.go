@@ -0,0 +1,65 @@
+package tracer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logFields returns the fields of a log record as strings.
+func logFields(fields []log.Field) map[string]string {
+	m := make(map[string]string, len(fields))
+	for _, field := range fields {
+		m[field.Key()] = fmt.Sprint(field.Value())
+	}
+	return m
+}
+
+func TestRecordError(t *testing.T) {
+	reporter := NewInMemoryReporter()
+	registry := testMetricsRegistry{metrics.NewRegistry()}
+	tracer := New(reporter, WithSampler(NeverSample{}), WithMetricsRegistry(registry))
+
+	root := errors.New("connection refused")
+	err := fmt.Errorf("query users: %w", fmt.Errorf("dial: %w", root))
+	span := tracer.StartSpan("query")
+	RecordError(span, err, WithStackTrace())
+	RecordError(span, nil)
+	span.Finish()
+
+	spans := reporter.getSampledSpans()
+	require.Len(t, spans, 1, "spans with errors are sampled")
+	assert.Equal(t, int64(1), registry.count("spans.sampled.error"))
+	assert.Equal(t, true, spans[0].Tags["error"])
+	require.Len(t, spans[0].Logs, 1)
+
+	fields := logFields(spans[0].Logs[0].Fields)
+	assert.Equal(t, "error", fields["event"])
+	assert.Equal(t, "*fmt.wrapError", fields["error.kind"])
+	assert.Equal(t, err.Error(), fields["error.object"])
+	assert.Equal(t, err.Error(), fields["message"])
+	assert.Contains(t, fields["stack"], "tracer.TestRecordError")
+	assert.Equal(t, "dial: connection refused", fields["error.cause.1"])
+	assert.Equal(t, "*fmt.wrapError", fields["error.cause.1.kind"])
+	assert.Equal(t, "connection refused", fields["error.cause.2"])
+	assert.Equal(t, "*errors.errorString", fields["error.cause.2.kind"])
+	assert.NotContains(t, fields, "error.cause.3")
+}
+
+func TestRecordError_Kind(t *testing.T) {
+	reporter := NewInMemoryReporter()
+	tracer := New(reporter)
+	span := tracer.StartSpan("query")
+	RecordError(span, errors.New("timeout"), WithErrorKind("Timeout"))
+	span.Finish()
+
+	fields := logFields(reporter.getSpans()[0].Logs[0].Fields)
+	assert.Equal(t, "Timeout", fields["error.kind"])
+	assert.NotContains(t, fields, "stack")
+	assert.NotContains(t, fields, "error.cause.1")
+}
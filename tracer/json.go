@@ -0,0 +1,434 @@
+package tracer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// The JSON encoding of spans keeps the type of tag values and log fields, so that spans can be
+// stored and decoded back without loss. Tags are sorted by key so that the encoding is stable, and the tags
+// with several values are repeated.
+//
+// A span is encoded as:
+//
+//	{
+//	  "context": {"traceId": "...", "spanId": "...", "sampled": true, "baggage": {"k": "v"}},
+//	  "parentSpanId": "...",
+//	  "references": [{"type": "child_of", "context": {"traceId": "...", "spanId": "..."}}],
+//	  "operation": "getUser",
+//	  "component": "http",
+//	  "start": "2019-01-02T15:04:05.123456789Z",
+//	  "durationNanos": 1500000,
+//	  "tags": [{"key": "http.status_code", "type": "int", "value": 200}],
+//	  "logs": [{"timestamp": "...", "fields": [{"key": "event", "type": "string", "value": "retry"}]}]
+//	}
+//
+// NaN and infinite floats are encoded as the strings "NaN", "+Inf" and "-Inf".
+
+const (
+	refChildOf     = "child_of"
+	refFollowsFrom = "follows_from"
+)
+
+// fieldBits are the sizes of the numeric field types.
+var fieldBits = map[string]int{
+	"int": strconv.IntSize, "int8": 8, "int16": 16, "int32": 32, "int64": 64,
+	"uint": strconv.IntSize, "uint8": 8, "uint16": 16, "uint32": 32, "uint64": 64,
+	"float32": 32, "float64": 64,
+}
+
+type jsonContext struct {
+	TraceID string            `json:"traceId"`
+	SpanID  string            `json:"spanId"`
+	Sampled *bool             `json:"sampled,omitempty"`
+	Baggage map[string]string `json:"baggage,omitempty"`
+}
+
+type jsonReference struct {
+	Type    string      `json:"type"`
+	Context SpanContext `json:"context"`
+}
+
+type jsonField struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+type jsonLog struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Fields    []jsonField `json:"fields"`
+}
+
+type jsonSpan struct {
+	Context       SpanContext     `json:"context"`
+	ParentSpanID  string          `json:"parentSpanId,omitempty"`
+	References    []jsonReference `json:"references,omitempty"`
+	Operation     string          `json:"operation"`
+	Component     string          `json:"component,omitempty"`
+	Start         time.Time       `json:"start"`
+	DurationNanos int64           `json:"durationNanos"`
+	Tags          []jsonField     `json:"tags,omitempty"`
+	Logs          []jsonLog       `json:"logs,omitempty"`
+}
+
+// MarshalJSON encodes the span context as JSON.
+func (c SpanContext) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonContext{
+		TraceID: c.TraceID,
+		SpanID:  c.SpanID,
+		Sampled: c.Sampled,
+		Baggage: c.Baggage,
+	})
+}
+
+// UnmarshalJSON decodes a span context encoded by MarshalJSON.
+func (c *SpanContext) UnmarshalJSON(data []byte) error {
+	var jc jsonContext
+	if err := json.Unmarshal(data, &jc); err != nil {
+		return err
+	}
+	*c = SpanContext{TraceID: jc.TraceID, SpanID: jc.SpanID, Sampled: jc.Sampled, Baggage: jc.Baggage}
+	return nil
+}
+
+// MarshalJSON encodes the span as JSON. References to span contexts of other tracers are skipped.
+func (s RawSpan) MarshalJSON() ([]byte, error) {
+	js := jsonSpan{
+		Context:       s.Context,
+		ParentSpanID:  s.ParentSpanID,
+		Operation:     s.Operation,
+		Component:     s.Component,
+		Start:         s.Start,
+		DurationNanos: s.Duration.Nanoseconds(),
+	}
+	for _, ref := range s.References {
+		refCtx, ok := ref.ReferencedContext.(SpanContext)
+		if !ok {
+			continue
+		}
+		refType := refChildOf
+		if ref.Type == opentracing.FollowsFromRef {
+			refType = refFollowsFrom
+		}
+		js.References = append(js.References, jsonReference{Type: refType, Context: refCtx})
+	}
+
+	keys := make([]string, 0, len(s.Tags))
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range append([]interface{}{s.Tags[k]}, s.AdditionalTags[k]...) {
+			field, err := encodeField(k, v)
+			if err != nil {
+				return nil, err
+			}
+			js.Tags = append(js.Tags, field)
+		}
+	}
+
+	for _, lr := range s.Logs {
+		enc := &fieldEncoder{}
+		for _, field := range lr.Fields {
+			if err, ok := field.Value().(error); ok {
+				enc.emit(field.Key(), err)
+			} else {
+				field.Marshal(enc)
+			}
+		}
+		if enc.err != nil {
+			return nil, enc.err
+		}
+		if enc.fields == nil {
+			enc.fields = []jsonField{}
+		}
+		js.Logs = append(js.Logs, jsonLog{Timestamp: lr.Timestamp, Fields: enc.fields})
+	}
+	return json.Marshal(js)
+}
+
+// UnmarshalJSON decodes a span encoded by MarshalJSON.
+func (s *RawSpan) UnmarshalJSON(data []byte) error {
+	var js jsonSpan
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+	span := RawSpan{
+		Context:      js.Context,
+		ParentSpanID: js.ParentSpanID,
+		Operation:    js.Operation,
+		Component:    js.Component,
+		Start:        js.Start,
+		Duration:     time.Duration(js.DurationNanos),
+	}
+
+	for i, ref := range js.References {
+		var refType opentracing.SpanReferenceType
+		switch ref.Type {
+		case refChildOf:
+			refType = opentracing.ChildOfRef
+		case refFollowsFrom:
+			refType = opentracing.FollowsFromRef
+		default:
+			return fmt.Errorf("reference %d: unknown type %q", i, ref.Type)
+		}
+		span.References = append(span.References, opentracing.SpanReference{Type: refType, ReferencedContext: ref.Context})
+	}
+
+	if len(js.Tags) > 0 {
+		span.Tags = make(opentracing.Tags, len(js.Tags))
+		for _, tag := range js.Tags {
+			v, err := decodeValue(tag)
+			if err != nil {
+				return fmt.Errorf("tag %q: %v", tag.Key, err)
+			}
+			if _, found := span.Tags[tag.Key]; found {
+				if span.AdditionalTags == nil {
+					span.AdditionalTags = make(map[string][]interface{})
+				}
+				span.AdditionalTags[tag.Key] = append(span.AdditionalTags[tag.Key], v)
+			} else {
+				span.Tags[tag.Key] = v
+			}
+		}
+	}
+
+	for i, jl := range js.Logs {
+		lr := opentracing.LogRecord{Timestamp: jl.Timestamp, Fields: make([]log.Field, 0, len(jl.Fields))}
+		for _, field := range jl.Fields {
+			lf, err := decodeField(field)
+			if err != nil {
+				return fmt.Errorf("log %d field %q: %v", i, field.Key, err)
+			}
+			lr.Fields = append(lr.Fields, lf)
+		}
+		span.Logs = append(span.Logs, lr)
+	}
+
+	*s = span
+	return nil
+}
+
+// fieldEncoder collects the log fields of a log record, expanding lazy loggers.
+type fieldEncoder struct {
+	fields []jsonField
+	err    error
+}
+
+func (e *fieldEncoder) emit(key string, value interface{}) {
+	field, err := encodeField(key, value)
+	if err != nil {
+		if e.err == nil {
+			e.err = err
+		}
+		return
+	}
+	e.fields = append(e.fields, field)
+}
+
+func (e *fieldEncoder) EmitString(key, value string)             { e.emit(key, value) }
+func (e *fieldEncoder) EmitBool(key string, value bool)          { e.emit(key, value) }
+func (e *fieldEncoder) EmitInt(key string, value int)            { e.emit(key, value) }
+func (e *fieldEncoder) EmitInt32(key string, value int32)        { e.emit(key, value) }
+func (e *fieldEncoder) EmitInt64(key string, value int64)        { e.emit(key, value) }
+func (e *fieldEncoder) EmitUint32(key string, value uint32)      { e.emit(key, value) }
+func (e *fieldEncoder) EmitUint64(key string, value uint64)      { e.emit(key, value) }
+func (e *fieldEncoder) EmitFloat32(key string, value float32)    { e.emit(key, value) }
+func (e *fieldEncoder) EmitFloat64(key string, value float64)    { e.emit(key, value) }
+func (e *fieldEncoder) EmitObject(key string, value interface{}) { e.emit(key, jsonObject{value}) }
+func (e *fieldEncoder) EmitLazyLogger(value log.LazyLogger)      { value(e) }
+
+// jsonObject marks log values recorded with log.Object.
+type jsonObject struct {
+	value interface{}
+}
+
+// encodeField returns the typed JSON encoding of a value. Values of other types than the basic ones
+// are encoded as objects, using their JSON encoding if they have one, and their string representation otherwise.
+func encodeField(key string, value interface{}) (jsonField, error) {
+	var typ string
+	var v interface{} = value
+	switch val := value.(type) {
+	case string:
+		typ = "string"
+	case bool:
+		typ = "bool"
+	case int:
+		typ = "int"
+	case int8:
+		typ = "int8"
+	case int16:
+		typ = "int16"
+	case int32:
+		typ = "int32"
+	case int64:
+		typ = "int64"
+	case uint:
+		typ = "uint"
+	case uint8:
+		typ = "uint8"
+	case uint16:
+		typ = "uint16"
+	case uint32:
+		typ = "uint32"
+	case uint64:
+		typ = "uint64"
+	case float32:
+		typ, v = "float32", jsonFloat(float64(val))
+	case float64:
+		typ, v = "float64", jsonFloat(val)
+	case error:
+		typ, v = "error", val.Error()
+	case jsonObject:
+		return encodeObject(key, val.value)
+	default:
+		return encodeObject(key, value)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return jsonField{}, fmt.Errorf("encoding %q: %v", key, err)
+	}
+	return jsonField{Key: key, Type: typ, Value: raw}, nil
+}
+
+func encodeObject(key string, value interface{}) (jsonField, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		raw, err = json.Marshal(fmt.Sprint(value))
+		if err != nil {
+			return jsonField{}, fmt.Errorf("encoding %q: %v", key, err)
+		}
+	}
+	return jsonField{Key: key, Type: "object", Value: raw}, nil
+}
+
+// jsonFloat returns the float, or its string representation if JSON cannot represent it.
+func jsonFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return f
+}
+
+// decodeValue returns the value of a field encoded by encodeField, with its original type.
+func decodeValue(field jsonField) (interface{}, error) {
+	raw := string(field.Value)
+	switch field.Type {
+	case "string", "error":
+		var s string
+		err := json.Unmarshal(field.Value, &s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %s", field.Type, raw)
+		}
+		if field.Type == "error" {
+			return errors.New(s), nil
+		}
+		return s, nil
+	case "bool":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool value %s", raw)
+		}
+		return b, nil
+	case "int", "int8", "int16", "int32", "int64":
+		i, err := strconv.ParseInt(raw, 10, fieldBits[field.Type])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %s", field.Type, raw)
+		}
+		switch field.Type {
+		case "int":
+			return int(i), nil
+		case "int8":
+			return int8(i), nil
+		case "int16":
+			return int16(i), nil
+		case "int32":
+			return int32(i), nil
+		}
+		return i, nil
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		u, err := strconv.ParseUint(raw, 10, fieldBits[field.Type])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %s", field.Type, raw)
+		}
+		switch field.Type {
+		case "uint":
+			return uint(u), nil
+		case "uint8":
+			return uint8(u), nil
+		case "uint16":
+			return uint16(u), nil
+		case "uint32":
+			return uint32(u), nil
+		}
+		return u, nil
+	case "float32", "float64":
+		s := raw
+		if len(raw) > 0 && raw[0] == '"' {
+			if err := json.Unmarshal(field.Value, &s); err != nil {
+				return nil, fmt.Errorf("invalid %s value %s", field.Type, raw)
+			}
+		}
+		f, err := strconv.ParseFloat(s, fieldBits[field.Type])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %s", field.Type, raw)
+		}
+		if field.Type == "float32" {
+			return float32(f), nil
+		}
+		return f, nil
+	case "object":
+		var v interface{}
+		if err := json.Unmarshal(field.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid object value %s", raw)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", field.Type)
+}
+
+// decodeField returns the log field encoded by encodeField.
+func decodeField(field jsonField) (log.Field, error) {
+	v, err := decodeValue(field)
+	if err != nil {
+		return log.Field{}, err
+	}
+	if field.Type == "object" {
+		return log.Object(field.Key, v), nil
+	}
+	switch val := v.(type) {
+	case string:
+		return log.String(field.Key, val), nil
+	case bool:
+		return log.Bool(field.Key, val), nil
+	case int:
+		return log.Int(field.Key, val), nil
+	case int32:
+		return log.Int32(field.Key, val), nil
+	case int64:
+		return log.Int64(field.Key, val), nil
+	case uint32:
+		return log.Uint32(field.Key, val), nil
+	case uint64:
+		return log.Uint64(field.Key, val), nil
+	case float32:
+		return log.Float32(field.Key, val), nil
+	case float64:
+		return log.Float64(field.Key, val), nil
+	case error:
+		if field.Key == "error.object" {
+			return log.Error(val), nil
+		}
+	}
+	return log.Object(field.Key, v), nil
+}
@@ -0,0 +1,117 @@
+package tracer
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawSpan_JSONRoundTrip(t *testing.T) {
+	sampled := true
+	parent := SpanContext{TraceID: "trace", SpanID: "parent"}
+	linked := SpanContext{TraceID: "other", SpanID: "linked"}
+	start := time.Date(2019, 1, 2, 15, 4, 5, 123456789, time.UTC)
+	span := RawSpan{
+		Context:      SpanContext{TraceID: "trace", SpanID: "span", Sampled: &sampled, Baggage: map[string]string{"user": "42"}},
+		ParentSpanID: "parent",
+		References: []opentracing.SpanReference{
+			{Type: opentracing.ChildOfRef, ReferencedContext: parent},
+			{Type: opentracing.FollowsFromRef, ReferencedContext: linked},
+		},
+		Operation: "getUser",
+		Component: "http",
+		Start:     start,
+		Duration:  1500 * time.Microsecond,
+		Tags: opentracing.Tags{
+			"error":            true,
+			"http.status_code": 500,
+			"peer.port":        uint16(8080),
+			"ratio":            math.Inf(1),
+			"retries":          int64(math.MaxInt64),
+			"span.kind":        "client",
+			"db.table":         "users",
+		},
+		AdditionalTags: map[string][]interface{}{"db.table": {"orders", 3}},
+		Logs: []opentracing.LogRecord{{
+			Timestamp: start.Add(time.Millisecond),
+			Fields: []log.Field{
+				log.String("event", "error"),
+				log.Error(errors.New("timeout")),
+				log.Int32("attempt", 3),
+				log.Uint64("bytes", math.MaxUint64),
+				log.Float32("load", 0.5),
+				log.Float64("nan", math.NaN()),
+				log.Object("headers", map[string]interface{}{"accept": "json"}),
+			},
+		}},
+	}
+
+	data, err := json.Marshal(span)
+	require.NoError(t, err)
+
+	var decoded RawSpan
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, span.Context, decoded.Context)
+	assert.Equal(t, span.ParentSpanID, decoded.ParentSpanID)
+	assert.Equal(t, span.References, decoded.References)
+	assert.Equal(t, span.Operation, decoded.Operation)
+	assert.Equal(t, span.Component, decoded.Component)
+	assert.True(t, span.Start.Equal(decoded.Start))
+	assert.Equal(t, span.Duration, decoded.Duration)
+	assert.Equal(t, span.Tags, decoded.Tags)
+	assert.Equal(t, span.AdditionalTags, decoded.AdditionalTags)
+
+	require.Len(t, decoded.Logs, 1)
+	fields := decoded.Logs[0].Fields
+	require.Len(t, fields, 7)
+	assert.Equal(t, log.String("event", "error"), fields[0])
+	assert.Equal(t, "error.object", fields[1].Key())
+	assert.EqualError(t, fields[1].Value().(error), "timeout")
+	assert.Equal(t, log.Int32("attempt", 3), fields[2])
+	assert.Equal(t, log.Uint64("bytes", math.MaxUint64), fields[3])
+	assert.Equal(t, log.Float32("load", 0.5), fields[4])
+	assert.True(t, math.IsNaN(fields[5].Value().(float64)))
+	assert.Equal(t, log.Object("headers", map[string]interface{}{"accept": "json"}), fields[6])
+
+	// the encoding is stable
+	again, err := json.Marshal(decoded)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(again))
+}
+
+func TestRawSpan_MarshalJSONLazyLogger(t *testing.T) {
+	span := RawSpan{Logs: []opentracing.LogRecord{{Fields: []log.Field{
+		log.Lazy(func(fv log.Encoder) {
+			fv.EmitString("event", "lazy")
+			fv.EmitInt("count", 2)
+		}),
+	}}}}
+
+	data, err := json.Marshal(span)
+	require.NoError(t, err)
+	var decoded RawSpan
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, []log.Field{log.String("event", "lazy"), log.Int("count", 2)}, decoded.Logs[0].Fields)
+}
+
+func TestRawSpan_UnmarshalJSONErrors(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		err  string
+	}{
+		{`{"references":[{"type":"parent_of","context":{}}]}`, `reference 0: unknown type "parent_of"`},
+		{`{"tags":[{"key":"port","type":"uint8","value":300}]}`, `tag "port": invalid uint8 value 300`},
+		{`{"tags":[{"key":"k","type":"complex","value":1}]}`, `tag "k": unknown type "complex"`},
+		{`{"logs":[{"fields":[{"key":"ok","type":"bool","value":"yes"}]}]}`, `log 0 field "ok": invalid bool value "yes"`},
+	} {
+		var span RawSpan
+		assert.EqualError(t, json.Unmarshal([]byte(tc.data), &span), tc.err)
+	}
+}
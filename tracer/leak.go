@@ -0,0 +1,181 @@
+package tracer
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	leakedTag                = "leaked"
+	maxStackDepth            = 32
+	defaultLeakCheckInterval = time.Minute
+)
+
+// LeakOption allows customizing the detection of leaked spans.
+type LeakOption func(*leakConfig)
+
+type leakConfig struct {
+	ttl         time.Duration
+	interval    time.Duration
+	forceFinish bool
+}
+
+// LeakCheckInterval sets how often the live spans are checked. Defaults to the TTL, at most 1 minute.
+func LeakCheckInterval(interval time.Duration) LeakOption {
+	return func(cfg *leakConfig) {
+		cfg.interval = interval
+	}
+}
+
+// LeakForceFinish finishes the leaked spans, tagged with "leaked=true", so they are reported.
+func LeakForceFinish() LeakOption {
+	return func(cfg *leakConfig) {
+		cfg.forceFinish = true
+	}
+}
+
+// WithLeakDetection tracks the live spans and logs the spans not finished after the TTL, with their operation and
+// the stack where they were started. Leaked spans are counted in the "spans.leaked" metric and are no longer
+// tracked once logged, and the live spans not leaked are counted in the "spans.active" gauge. Capturing the stack
+// of each span has a cost, so it is meant for troubleshooting.
+func WithLeakDetection(ttl time.Duration, options ...LeakOption) Option {
+	return func(t *WavefrontTracer) {
+		cfg := &leakConfig{ttl: ttl, interval: ttl}
+		if cfg.interval > defaultLeakCheckInterval {
+			cfg.interval = defaultLeakCheckInterval
+		}
+		for _, option := range options {
+			option(cfg)
+		}
+		t.leakConfig = cfg
+	}
+}
+
+// leakDetector tracks the live spans until they finish or are reported as leaked. Its goroutine runs while there
+// are tracked spans, since the tracer is never closed.
+type leakDetector struct {
+	leakConfig
+	leaked metrics.Counter
+
+	mtx     sync.Mutex // protects the fields below
+	spans   map[*spanImpl]*liveSpan
+	running bool
+}
+
+type liveSpan struct {
+	start time.Time
+	stack []uintptr
+}
+
+func newLeakDetector(cfg leakConfig, registry MetricsRegistry) *leakDetector {
+	d := &leakDetector{
+		leakConfig: cfg,
+		leaked:     metrics.NilCounter{},
+		spans:      make(map[*spanImpl]*liveSpan),
+	}
+	if registry != nil {
+		d.leaked = registry.TracerDeltaCounter("spans.leaked", nil)
+		registry.TracerGauge("spans.active", d.active)
+	}
+	return d
+}
+
+// track registers a started span, with the stack of the caller of StartSpan.
+func (d *leakDetector) track(s *spanImpl) {
+	stack := make([]uintptr, maxStackDepth)
+	stack = stack[:runtime.Callers(3, stack)]
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.spans[s] = &liveSpan{start: time.Now(), stack: stack}
+	if !d.running {
+		d.running = true
+		go d.run()
+	}
+}
+
+func (d *leakDetector) untrack(s *spanImpl) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	delete(d.spans, s)
+}
+
+func (d *leakDetector) active() int64 {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return int64(len(d.spans))
+}
+
+func (d *leakDetector) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !d.check() {
+			return
+		}
+	}
+}
+
+// check reports and untracks the spans alive for longer than the TTL, so that a span never finished is not kept
+// forever. It returns false when no span is tracked anymore, to stop the goroutine.
+func (d *leakDetector) check() bool {
+	type leak struct {
+		span *spanImpl
+		live liveSpan
+	}
+	var leaks []leak
+	now := time.Now()
+
+	d.mtx.Lock()
+	for s, live := range d.spans {
+		if now.Sub(live.start) > d.ttl {
+			leaks = append(leaks, leak{s, *live})
+			delete(d.spans, s)
+		}
+	}
+	d.mtx.Unlock()
+
+	// the spans are reported and finished without holding the lock, since finishing a span untracks it
+	for _, leak := range leaks {
+		d.leaked.Inc(1)
+		leak.span.Lock()
+		operation := leak.span.raw.Operation
+		leak.span.Unlock()
+		log.Printf("span %q not finished %v after it started at:\n%s", operation,
+			now.Sub(leak.live.start).Round(time.Millisecond), formatStack(leak.live.stack))
+		if d.forceFinish {
+			leak.span.finish(opentracing.FinishOptions{}, true)
+		}
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if len(d.spans) > 0 {
+		return true
+	}
+	d.running = false
+	return false
+}
+
+func formatStack(stack []uintptr) string {
+	var sb strings.Builder
+	if len(stack) == 0 {
+		return sb.String()
+	}
+	frames := runtime.CallersFrames(stack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&sb, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
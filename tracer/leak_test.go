@@ -0,0 +1,101 @@
+package tracer
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for the log output of the leak detector.
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+func captureLog(t *testing.T) *syncBuffer {
+	out := &syncBuffer{}
+	log.SetOutput(out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return out
+}
+
+func TestLeakDetection(t *testing.T) {
+	out := captureLog(t)
+	registry := testMetricsRegistry{metrics.NewRegistry()}
+	reporter := NewInMemoryReporter()
+	tracer := New(reporter, WithMetricsRegistry(registry), WithLeakDetection(20*time.Millisecond))
+	active := registry.Get("spans.active").(metrics.Gauge)
+
+	finished := tracer.StartSpan("finished")
+	leaked := tracer.StartSpan("leaked")
+	assert.Equal(t, int64(2), active.Value())
+	finished.Finish()
+	assert.Equal(t, int64(1), active.Value())
+
+	require.Eventually(t, func() bool { return registry.count("spans.leaked") == 1 }, 5*time.Second, 5*time.Millisecond)
+	assert.Contains(t, out.String(), `span "leaked" not finished`)
+	assert.Contains(t, out.String(), "tracer.TestLeakDetection", "the stack where the span started is logged")
+	assert.NotContains(t, out.String(), `span "finished"`)
+
+	// leaked spans are reported once, and no longer tracked, so a span never finished is not kept
+	detector := tracer.(*WavefrontTracer).leaks
+	require.Eventually(t, func() bool {
+		detector.mtx.Lock()
+		defer detector.mtx.Unlock()
+		return !detector.running
+	}, 5*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), registry.count("spans.leaked"))
+	assert.Equal(t, int64(0), active.Value())
+	detector.mtx.Lock()
+	assert.Empty(t, detector.spans)
+	detector.mtx.Unlock()
+	assert.Len(t, reporter.getSpans(), 1)
+
+	leaked.Finish()
+	assert.Equal(t, int64(0), active.Value())
+	assert.Len(t, reporter.getSpans(), 2)
+	assert.Nil(t, reporter.getSpans()[1].Tags[leakedTag])
+}
+
+func TestLeakDetection_ForceFinish(t *testing.T) {
+	captureLog(t)
+	registry := testMetricsRegistry{metrics.NewRegistry()}
+	reporter := NewInMemoryReporter()
+	tracer := New(reporter, WithMetricsRegistry(registry),
+		WithLeakDetection(time.Hour, LeakCheckInterval(5*time.Millisecond)))
+	long := tracer.StartSpan("long")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(0), registry.count("spans.leaked"), "not alive longer than the TTL")
+	long.Finish()
+
+	registry = testMetricsRegistry{metrics.NewRegistry()}
+	tracer = New(reporter, WithMetricsRegistry(registry), WithLeakDetection(10*time.Millisecond, LeakForceFinish()))
+	tracer.StartSpan("leaked")
+	require.Eventually(t, func() bool { return len(reporter.getSpans()) == 2 }, 5*time.Second, 5*time.Millisecond)
+
+	span := reporter.getSpans()[1]
+	assert.Equal(t, "leaked", span.Operation)
+	assert.Equal(t, true, span.Tags[leakedTag])
+	assert.Equal(t, int64(1), registry.count("spans.leaked"))
+	assert.Equal(t, int64(0), registry.Get("spans.active").(metrics.Gauge).Value())
+}
@@ -0,0 +1,117 @@
+package tracer
+
+import (
+	"github.com/opentracing/opentracing-go"
+	"github.com/rcrowley/go-metrics"
+)
+
+// MetricsRegistry provides the delta counters and gauges of the internal metrics of the tracer. The
+// WavefrontSpanReporter implements it to send them along with its own internal metrics, named
+// "~sdk.go.opentracing.tracer.<name>".
+type MetricsRegistry interface {
+	TracerDeltaCounter(name string, tags map[string]string) metrics.Counter
+	TracerGauge(name string, f func() int64) metrics.Gauge
+}
+
+// WithMetricsRegistry sets the registry of the internal metrics of the tracer. Defaults to the reporter of the
+// tracer if it implements MetricsRegistry, such as the WavefrontSpanReporter. Otherwise, no metrics are collected.
+func WithMetricsRegistry(registry MetricsRegistry) Option {
+	return func(t *WavefrontTracer) {
+		t.metricsRegistry = registry
+	}
+}
+
+// propagationFormats are the names of the propagation formats in the metrics.
+const (
+	formatTextMap     = "text_map"
+	formatHTTPHeaders = "http_headers"
+	formatBinary      = "binary"
+	formatJaeger      = "jaeger"
+	formatZipkin      = "zipkin"
+	formatDelegator   = "delegator"
+	formatUnsupported = "unsupported"
+)
+
+var propagationFormats = []string{
+	formatTextMap, formatHTTPHeaders, formatBinary, formatJaeger, formatZipkin, formatDelegator, formatUnsupported,
+}
+
+// tracerMetrics are the internal metrics of the tracer.
+type tracerMetrics struct {
+	spansStarted             metrics.Counter
+	spansStartedSampled      metrics.Counter // with a sampling decision to sample when started
+	spansStartedUnsampled    metrics.Counter // with a sampling decision not to sample when started
+	spansFinished            metrics.Counter
+	spansFinishedAgain       metrics.Counter // finished more than once, ignored
+	spansModifiedAfterFinish metrics.Counter // ignored modifications of finished spans
+	spansSampledLate         metrics.Counter // sampled by a late sampler
+	spansSampledDebug        metrics.Counter // sampled because of the debug tag
+	spansSampledError        metrics.Counter // sampled because of the error tag
+	injectErrors             map[string]metrics.Counter
+	extractErrors            map[string]metrics.Counter
+}
+
+func newTracerMetrics(registry MetricsRegistry) tracerMetrics {
+	counter := func(name string, tags map[string]string) metrics.Counter {
+		if registry == nil {
+			return metrics.NilCounter{}
+		}
+		return registry.TracerDeltaCounter(name, tags)
+	}
+	m := tracerMetrics{
+		spansStarted:             counter("spans.started", nil),
+		spansStartedSampled:      counter("spans.started.sampled", nil),
+		spansStartedUnsampled:    counter("spans.started.unsampled", nil),
+		spansFinished:            counter("spans.finished", nil),
+		spansFinishedAgain:       counter("spans.finished.again", nil),
+		spansModifiedAfterFinish: counter("spans.modified.after_finish", nil),
+		spansSampledLate:         counter("spans.sampled.late", nil),
+		spansSampledDebug:        counter("spans.sampled.debug", nil),
+		spansSampledError:        counter("spans.sampled.error", nil),
+		injectErrors:             make(map[string]metrics.Counter, len(propagationFormats)),
+		extractErrors:            make(map[string]metrics.Counter, len(propagationFormats)),
+	}
+	for _, format := range propagationFormats {
+		m.injectErrors[format] = counter("propagation.inject.errors", map[string]string{"format": format})
+		m.extractErrors[format] = counter("propagation.extract.errors", map[string]string{"format": format})
+	}
+	return m
+}
+
+func (m tracerMetrics) spanStarted(ctx SpanContext) {
+	m.spansStarted.Inc(1)
+	if ctx.IsSampled() {
+		if *ctx.Sampled {
+			m.spansStartedSampled.Inc(1)
+		} else {
+			m.spansStartedUnsampled.Inc(1)
+		}
+	}
+}
+
+// propagated counts the failure to inject or extract a span context. A missing span context is not a failure.
+func (m tracerMetrics) propagated(counters map[string]metrics.Counter, format interface{}, err error) {
+	if err != nil && err != opentracing.ErrSpanContextNotFound {
+		counters[formatName(format)].Inc(1)
+	}
+}
+
+func formatName(format interface{}) string {
+	switch format.(type) {
+	case JaegerWavefrontPropagator:
+		return formatJaeger
+	case ZipkinWavefrontPropagator:
+		return formatZipkin
+	case delegatorType:
+		return formatDelegator
+	}
+	switch format {
+	case opentracing.TextMap:
+		return formatTextMap
+	case opentracing.HTTPHeaders:
+		return formatHTTPHeaders
+	case opentracing.Binary:
+		return formatBinary
+	}
+	return formatUnsupported
+}
@@ -0,0 +1,109 @@
+package tracer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMetricsRegistry keys the metrics by name, and the format tag of the counters.
+type testMetricsRegistry struct {
+	metrics.Registry
+}
+
+func (r testMetricsRegistry) TracerDeltaCounter(name string, tags map[string]string) metrics.Counter {
+	if format, ok := tags["format"]; ok {
+		name += "." + format
+	}
+	return metrics.GetOrRegisterCounter(name, r.Registry)
+}
+
+func (r testMetricsRegistry) TracerGauge(name string, f func() int64) metrics.Gauge {
+	return r.GetOrRegister(name, metrics.NewFunctionalGauge(f)).(metrics.Gauge)
+}
+
+func (r testMetricsRegistry) count(name string) int64 {
+	return metrics.GetOrRegisterCounter(name, r.Registry).Count()
+}
+
+func TestTracerMetrics_Sampling(t *testing.T) {
+	registry := testMetricsRegistry{metrics.NewRegistry()}
+	tracer := New(NewInMemoryReporter(), WithSampler(NeverSample{}), WithSampler(DurationSampler{Duration: -1}),
+		WithMetricsRegistry(registry))
+
+	root := tracer.StartSpan("root")
+	tracer.StartSpan("child", opentracing.ChildOf(root.Context())).Finish()
+	root.Finish()
+	assert.Equal(t, int64(2), registry.count("spans.started"))
+	assert.Equal(t, int64(0), registry.count("spans.started.sampled"))
+	assert.Equal(t, int64(2), registry.count("spans.started.unsampled"))
+	assert.Equal(t, int64(2), registry.count("spans.finished"))
+	assert.Equal(t, int64(2), registry.count("spans.sampled.late"))
+
+	tracer = New(NewInMemoryReporter(), WithSampler(NeverSample{}), WithMetricsRegistry(registry))
+	tracer.StartSpan("debug", opentracing.Tag{Key: "debug", Value: true}).Finish()
+	tracer.StartSpan("error", opentracing.Tag{Key: "error", Value: "true"}).Finish()
+	tracer.StartSpan("unsampled").Finish()
+	assert.Equal(t, int64(1), registry.count("spans.sampled.debug"))
+	assert.Equal(t, int64(1), registry.count("spans.sampled.error"))
+	assert.Equal(t, int64(5), registry.count("spans.started.unsampled"))
+
+	tracer = New(NewInMemoryReporter(), WithMetricsRegistry(registry))
+	tracer.StartSpan("sampled").Finish()
+	assert.Equal(t, int64(1), registry.count("spans.started.sampled"))
+	assert.Equal(t, int64(6), registry.count("spans.finished"))
+}
+
+func TestTracerMetrics_PropagationErrors(t *testing.T) {
+	registry := testMetricsRegistry{metrics.NewRegistry()}
+	tracer := New(NewInMemoryReporter(), WithMetricsRegistry(registry))
+	span := tracer.StartSpan("op")
+
+	// a missing span context is not an error
+	_, err := tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier{})
+	assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+	assert.Equal(t, int64(0), registry.count("propagation.extract.errors.text_map"))
+
+	_, err = tracer.Extract(opentracing.HTTPHeaders, "not a carrier")
+	assert.Equal(t, opentracing.ErrInvalidCarrier, err)
+	assert.Equal(t, int64(1), registry.count("propagation.extract.errors.http_headers"))
+
+	err = tracer.Inject(span.Context(), opentracing.Binary, "not a writer")
+	assert.Equal(t, opentracing.ErrInvalidCarrier, err)
+	assert.Equal(t, int64(1), registry.count("propagation.inject.errors.binary"))
+
+	err = tracer.Inject(span.Context(), JaegerWavefrontPropagator{}, opentracing.TextMapCarrier{})
+	assert.Equal(t, opentracing.ErrUnsupportedFormat, err, "the jaeger propagator is not configured")
+	assert.Equal(t, int64(1), registry.count("propagation.inject.errors.jaeger"))
+
+	err = tracer.Inject(span.Context(), "custom", opentracing.TextMapCarrier{})
+	assert.Equal(t, opentracing.ErrUnsupportedFormat, err)
+	assert.Equal(t, int64(1), registry.count("propagation.inject.errors.unsupported"))
+
+	require.NoError(t, tracer.Inject(span.Context(), opentracing.TextMap, opentracing.TextMapCarrier{}))
+	assert.Equal(t, int64(0), registry.count("propagation.inject.errors.text_map"))
+}
+
+// metricsReporter is a reporter providing the metrics registry of the tracer.
+type metricsReporter struct {
+	*InMemorySpanReporter
+	testMetricsRegistry
+}
+
+func TestTracerMetrics_ReporterRegistry(t *testing.T) {
+	registry := testMetricsRegistry{metrics.NewRegistry()}
+	tracer := New(metricsReporter{NewInMemoryReporter(), registry})
+	tracer.StartSpan("op").Finish()
+	assert.Equal(t, int64(1), registry.count("spans.finished"))
+
+	// without registry, the metrics are not collected
+	assert.NotPanics(t, func() {
+		tracer := New(NewInMemoryReporter())
+		tracer.StartSpan("op").Finish()
+		tracer.Extract(opentracing.Binary, errors.New("not a reader"))
+	})
+}
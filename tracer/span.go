@@ -1,6 +1,7 @@
 package tracer
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -15,6 +16,7 @@ type spanImpl struct {
 	tracer     *WavefrontTracer
 	sync.Mutex // protects the fields below
 	raw        RawSpan
+	finished   bool // the span is reported once, and not modified after
 }
 
 // RawSpan holds the span information
@@ -44,10 +46,24 @@ type RawSpan struct {
 	// not to be enumerated here.
 	Tags opentracing.Tags
 
+	// The values added to tags with AddTag, after their first value in Tags. Nil if no tag has several values.
+	AdditionalTags map[string][]interface{}
+
 	// The span's "microlog".
 	Logs []opentracing.LogRecord
 }
 
+// AddTag adds a value to a tag of the span, keeping its previous values, for tags with several values such as
+// the tables queried by a span. Use SetTag to replace the values of a tag. The tags with a single value, "component"
+// and "sampling.priority", are set as with SetTag. Spans of other tracers only keep the last value.
+func AddTag(span opentracing.Span, key string, value interface{}) {
+	if s, ok := span.(*spanImpl); ok {
+		s.AddTag(key, value)
+		return
+	}
+	span.SetTag(key, value)
+}
+
 func (s *spanImpl) reset() {
 	s.tracer = nil
 	s.raw = RawSpan{
@@ -55,9 +71,25 @@ func (s *spanImpl) reset() {
 	}
 }
 
+// ignoreFinished reports whether the span is finished, in which case the modification done by the caller is
+// ignored and counted, or panics with WithStrictSpanLifecycle. It must be called with the lock held.
+func (s *spanImpl) ignoreFinished(method string) bool {
+	if !s.finished {
+		return false
+	}
+	s.tracer.metrics.spansModifiedAfterFinish.Inc(1)
+	if s.tracer.strictLifecycle {
+		panic(fmt.Sprintf("%s called on finished span %q", method, s.raw.Operation))
+	}
+	return true
+}
+
 func (s *spanImpl) SetOperationName(operationName string) opentracing.Span {
 	s.Lock()
 	defer s.Unlock()
+	if s.ignoreFinished("SetOperationName") {
+		return s
+	}
 	s.raw.Operation = operationName
 	return s
 }
@@ -65,23 +97,56 @@ func (s *spanImpl) SetOperationName(operationName string) opentracing.Span {
 func (s *spanImpl) SetTag(key string, value interface{}) opentracing.Span {
 	s.Lock()
 	defer s.Unlock()
+	if s.ignoreFinished("SetTag") {
+		return s
+	}
+	s.setTag(key, value, false)
+	return s
+}
+
+// AddTag adds a value to a tag, keeping its previous values, whereas SetTag replaces them. See the AddTag function.
+func (s *spanImpl) AddTag(key string, value interface{}) opentracing.Span {
+	s.Lock()
+	defer s.Unlock()
+	if s.ignoreFinished("AddTag") {
+		return s
+	}
+	s.setTag(key, value, true)
+	return s
+}
+
+// singleValuedTags are the tags AddTag sets as SetTag does, since the span keeps a single value for them.
+var singleValuedTags = map[string]bool{
+	"component":                  true,
+	string(ext.SamplingPriority): true,
+}
 
+// setTag sets the value of the tag, or adds it to the values of the tag. It must be called with the lock held.
+func (s *spanImpl) setTag(key string, value interface{}, add bool) {
 	if v, ok := value.(string); ok && v == "" {
-		return s
+		return
 	}
 
 	if key == "" || value == nil {
-		return s
+		return
 	}
 
 	if key == string(ext.SamplingPriority) {
 		if v, ok := value.(uint16); ok {
 			decision := v != 0
 			s.raw.Context.Sampled = &decision
-			return s
+			return
 		}
 	}
 
+	if _, found := s.raw.Tags[key]; found && add && !singleValuedTags[key] {
+		if s.raw.AdditionalTags == nil {
+			s.raw.AdditionalTags = make(map[string][]interface{})
+		}
+		s.raw.AdditionalTags[key] = append(s.raw.AdditionalTags[key], value)
+		return
+	}
+
 	if key == "component" {
 		if v, ok := value.(string); ok {
 			s.raw.Component = v
@@ -92,7 +157,7 @@ func (s *spanImpl) SetTag(key string, value interface{}) opentracing.Span {
 		s.raw.Tags = opentracing.Tags{}
 	}
 	s.raw.Tags[key] = value
-	return s
+	delete(s.raw.AdditionalTags, key)
 }
 
 func (s *spanImpl) LogKV(keyValues ...interface{}) {
@@ -107,7 +172,7 @@ func (s *spanImpl) LogFields(fields ...log.Field) {
 	s.Lock()
 	defer s.Unlock()
 
-	if len(fields) == 0 {
+	if s.ignoreFinished("LogFields") || len(fields) == 0 {
 		return
 	}
 	lr := opentracing.LogRecord{
@@ -128,6 +193,9 @@ func (s *spanImpl) LogEventWithPayload(event string, payload interface{}) {
 func (s *spanImpl) Log(ld opentracing.LogData) {
 	s.Lock()
 	defer s.Unlock()
+	if s.ignoreFinished("Log") {
+		return
+	}
 	s.appendLog(ld.ToLogRecord())
 }
 
@@ -140,25 +208,65 @@ func (s *spanImpl) Finish() {
 }
 
 func (s *spanImpl) FinishWithOptions(opts opentracing.FinishOptions) {
+	s.finish(opts, false)
+}
+
+// finish reports the span the first time it is called. Leaked spans are tagged, and not counted if they were
+// finished meanwhile.
+func (s *spanImpl) finish(opts opentracing.FinishOptions, leaked bool) {
 	finishTime := opts.FinishTime
 	if finishTime.IsZero() {
 		finishTime = time.Now()
 	}
-	duration := finishTime.Sub(s.raw.Start)
 
+	s.Lock()
+	if s.finished && leaked {
+		s.Unlock()
+		return
+	}
+	if s.finished {
+		s.tracer.metrics.spansFinishedAgain.Inc(1)
+		operation := s.raw.Operation
+		s.Unlock()
+		if s.tracer.strictLifecycle {
+			panic(fmt.Sprintf("Finish called on finished span %q", operation))
+		}
+		return
+	}
+	s.finished = true
+	s.tracer.metrics.spansFinished.Inc(1)
+	if leaked {
+		if s.raw.Tags == nil {
+			s.raw.Tags = opentracing.Tags{}
+		}
+		s.raw.Tags[leakedTag] = true
+	}
 	if len(opts.LogRecords) > 0 {
 		s.raw.Logs = append(s.raw.Logs, opts.LogRecords...)
 	}
+	s.raw.Duration = finishTime.Sub(s.raw.Start)
+	s.sample()
+	raw := s.raw
+	s.Unlock()
 
-	s.Lock()
-	defer s.Unlock()
+	if s.tracer.leaks != nil {
+		s.tracer.leaks.untrack(s)
+	}
+	// the span is no longer modified, so it is reported without holding the lock
+	s.tracer.reporter.ReportSpan(raw)
+}
 
-	s.raw.Duration = duration
+// sample makes the final sampling decision of the finished span. It must be called with the lock held.
+func (s *spanImpl) sample() {
+	metrics := s.tracer.metrics
 
 	if !s.raw.Context.IsSampled() || !*s.raw.Context.Sampled {
 		if len(s.tracer.lateSamplers) > 0 {
 			decision := s.tracer.lateSample(s.raw)
 			s.raw.Context.Sampled = &decision
+			if decision {
+				metrics.spansSampledLate.Inc(1)
+			}
 		}
 	}
 
@@ -170,6 +278,9 @@ func (s *spanImpl) FinishWithOptions(opts opentracing.FinishOptions) {
 			debugSpan = s.raw.Tags["debug"] == true
 		}
 		s.raw.Context.Sampled = &debugSpan
+		if debugSpan {
+			metrics.spansSampledDebug.Inc(1)
+		}
 	}
 
 	if !s.raw.Context.IsSampled() || !*s.raw.Context.Sampled {
@@ -180,8 +291,10 @@ func (s *spanImpl) FinishWithOptions(opts opentracing.FinishOptions) {
 			errd = s.raw.Tags["error"] == true
 		}
 		s.raw.Context.Sampled = &errd
+		if errd {
+			metrics.spansSampledError.Inc(1)
+		}
 	}
-	s.tracer.reporter.ReportSpan(s.raw)
 }
 
 func (s *spanImpl) Tracer() opentracing.Tracer {
@@ -189,12 +302,17 @@ func (s *spanImpl) Tracer() opentracing.Tracer {
 }
 
 func (s *spanImpl) Context() opentracing.SpanContext {
+	s.Lock()
+	defer s.Unlock()
 	return s.raw.Context
 }
 
 func (s *spanImpl) SetBaggageItem(key, val string) opentracing.Span {
 	s.Lock()
 	defer s.Unlock()
+	if s.ignoreFinished("SetBaggageItem") {
+		return s
+	}
 	s.raw.Context = s.raw.Context.WithBaggageItem(key, val)
 	return s
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSpan_Baggage(t *testing.T) {
@@ -267,3 +268,27 @@ func getAppTag(key string, tags map[string]interface{}) bool {
 	}
 	return false
 }
+
+func TestAddTag(t *testing.T) {
+	reporter := NewInMemoryReporter()
+	tracer := New(reporter)
+
+	span := tracer.StartSpan("query")
+	AddTag(span, "db.table", "users")
+	AddTag(span, "db.table", "orders")
+	AddTag(span, "db.table", "")
+	AddTag(span, "component", "sql")
+	AddTag(span, "component", "orm")
+	span.SetTag("peer.service", "db")
+	AddTag(span, "peer.service", "cache")
+	span.SetTag("peer.service", "replica")
+	span.Finish()
+
+	spans := reporter.getSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "users", spans[0].Tags["db.table"])
+	assert.Equal(t, "replica", spans[0].Tags["peer.service"], "SetTag replaces all the values")
+	assert.Equal(t, map[string][]interface{}{"db.table": {"orders"}}, spans[0].AdditionalTags)
+	assert.Equal(t, "orm", spans[0].Tags["component"], "the component has a single value")
+	assert.Equal(t, "orm", spans[0].Component)
+}
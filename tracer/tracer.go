@@ -43,6 +43,14 @@ type WavefrontTracer struct {
 	reporter      SpanReporter
 
 	generator Generator
+
+	metricsRegistry MetricsRegistry
+	metrics         tracerMetrics
+
+	leakConfig *leakConfig
+	leaks      *leakDetector // nil unless leak detection is enabled
+
+	strictLifecycle bool
 }
 
 // Option allows customizing the WavefrontTracer.
@@ -66,6 +74,15 @@ func WithGenerator(generator Generator) Option {
 	}
 }
 
+// WithStrictSpanLifecycle makes spans panic when they are finished twice, or modified once finished. By default,
+// those calls are ignored and counted in the "spans.finished.again" and "spans.modified.after_finish" metrics.
+// Meant for debugging and tests.
+func WithStrictSpanLifecycle() Option {
+	return func(t *WavefrontTracer) {
+		t.strictLifecycle = true
+	}
+}
+
 // WithJaegerPropagator configures Tracer to use Jaeger trace context propagation.
 func WithJaegerPropagator(traceId, baggagePrefix string) Option {
 	return func(args *WavefrontTracer) {
@@ -115,6 +132,13 @@ func New(reporter SpanReporter, options ...Option) opentracing.Tracer {
 	for _, option := range options {
 		option(tracer)
 	}
+	if tracer.metricsRegistry == nil {
+		tracer.metricsRegistry, _ = reporter.(MetricsRegistry)
+	}
+	tracer.metrics = newTracerMetrics(tracer.metricsRegistry)
+	if tracer.leakConfig != nil {
+		tracer.leaks = newLeakDetector(*tracer.leakConfig, tracer.metricsRegistry)
+	}
 	return tracer
 }
 
@@ -190,6 +214,10 @@ func (t *WavefrontTracer) StartSpan(operationName string, opts ...opentracing.St
 	for k, v := range tags {
 		sp.SetTag(k, v)
 	}
+	t.metrics.spanStarted(sp.raw.Context)
+	if t.leaks != nil {
+		t.leaks.track(sp)
+	}
 	return sp
 }
 
@@ -224,6 +252,12 @@ type delegatorType struct{}
 var Delegator delegatorType
 
 func (t *WavefrontTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	err := t.inject(sc, format, carrier)
+	t.metrics.propagated(t.metrics.injectErrors, format, err)
+	return err
+}
+
+func (t *WavefrontTracer) inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
 	if _, ok := format.(JaegerWavefrontPropagator); ok {
 		if t.jaegerWavefrontPropagator == nil {
 			return opentracing.ErrUnsupportedFormat
@@ -251,6 +285,12 @@ func (t *WavefrontTracer) Inject(sc opentracing.SpanContext, format interface{},
 }
 
 func (t *WavefrontTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	sc, err := t.extract(format, carrier)
+	t.metrics.propagated(t.metrics.extractErrors, format, err)
+	return sc, err
+}
+
+func (t *WavefrontTracer) extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
 	if _, ok := format.(JaegerWavefrontPropagator); ok {
 		if t.jaegerWavefrontPropagator == nil {
 			return nil, opentracing.ErrUnsupportedFormat
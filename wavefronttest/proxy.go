@@ -0,0 +1,417 @@
+// Package wavefronttest provides a fake Wavefront proxy for integration tests.
+//
+// The proxy listens on local TCP ports for metrics, distributions and tracing, parses the lines sent by
+// a Wavefront proxy sender, and records them so tests can assert on the spans, span logs, derived metrics,
+// histograms and heartbeats reported without network access:
+//
+//	proxy, err := wavefronttest.NewProxy()
+//	...
+//	defer proxy.Close()
+//	sender, err := senders.NewProxySender(proxy.Config())
+//	...
+//	spans := proxy.WaitForSpans(1, 5*time.Second)
+package wavefronttest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/internal/lineformat"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/reporter"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+// HeartbeatMetric is the name of the metric sent by the application heartbeat service.
+const HeartbeatMetric = "~component.heartbeat"
+
+// delta counter names start with ∆ (∆ - INCREMENT) or Δ (Δ - GREEK CAPITAL LETTER DELTA)
+var deltaPrefixes = []string{"∆", "Δ"}
+
+// Metric is a point received on the metrics port.
+type Metric struct {
+	Name string
+
+	// Delta is set for delta counters, whose Name is stripped of the delta prefix.
+	Delta bool
+
+	Value     float64
+	Timestamp int64 // 0 if the point has no timestamp
+	Source    string
+	Tags      map[string]string
+}
+
+// Centroid is a centroid of a Histogram.
+type Centroid struct {
+	Value float64
+	Count int
+}
+
+// Histogram is a distribution received on the distribution port.
+type Histogram struct {
+	Name string
+
+	// Granularity is "!M", "!H" or "!D" for minute, hour and day distributions.
+	Granularity string
+
+	Timestamp int64 // 0 if the distribution has no timestamp
+	Centroids []Centroid
+	Source    string
+	Tags      map[string]string
+}
+
+// Span is a span received on the tracing port, along with its span logs.
+type Span struct {
+	tracer.RawSpan
+	Source string
+}
+
+// Proxy is a fake Wavefront proxy listening on local TCP ports.
+type Proxy struct {
+	metricsListener      net.Listener
+	distributionListener net.Listener
+	tracingListener      net.Listener
+	wg                   sync.WaitGroup
+
+	mtx        sync.Mutex // protects the fields below
+	conns      map[net.Conn]struct{}
+	metrics    []Metric
+	histograms []Histogram
+	spans      []Span
+	errors     []error
+	closed     bool
+}
+
+// NewProxy returns a Proxy listening on local ports picked by the system.
+func NewProxy() (*Proxy, error) {
+	p := &Proxy{conns: make(map[net.Conn]struct{})}
+	var err error
+	if p.metricsListener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	if p.distributionListener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		p.metricsListener.Close()
+		return nil, err
+	}
+	if p.tracingListener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		p.metricsListener.Close()
+		p.distributionListener.Close()
+		return nil, err
+	}
+
+	p.serve(p.metricsListener, p.handleMetric)
+	p.serve(p.distributionListener, p.handleHistogram)
+	p.serve(p.tracingListener, p.handleTracing())
+	return p, nil
+}
+
+// Config returns the configuration of a proxy sender sending to the proxy and flushing every second.
+func (p *Proxy) Config() *senders.ProxyConfiguration {
+	return &senders.ProxyConfiguration{
+		Host:                 "127.0.0.1",
+		MetricsPort:          port(p.metricsListener),
+		DistributionPort:     port(p.distributionListener),
+		TracingPort:          port(p.tracingListener),
+		FlushIntervalSeconds: 1,
+	}
+}
+
+func port(l net.Listener) int {
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops listening and closes the open connections.
+func (p *Proxy) Close() error {
+	p.mtx.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mtx.Unlock()
+
+	p.metricsListener.Close()
+	p.distributionListener.Close()
+	p.tracingListener.Close()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *Proxy) serve(l net.Listener, handle func(line string) error) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			p.mtx.Lock()
+			if p.closed {
+				p.mtx.Unlock()
+				conn.Close()
+				return
+			}
+			p.conns[conn] = struct{}{}
+			p.mtx.Unlock()
+
+			p.wg.Add(1)
+			go p.read(conn, handle)
+		}
+	}()
+}
+
+func (p *Proxy) read(conn net.Conn, handle func(line string) error) {
+	defer p.wg.Done()
+	defer func() {
+		p.mtx.Lock()
+		delete(p.conns, conn)
+		p.mtx.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := handle(line); err != nil {
+			p.mtx.Lock()
+			p.errors = append(p.errors, fmt.Errorf("%v: %q", err, line))
+			p.mtx.Unlock()
+		}
+	}
+}
+
+// handleMetric parses a line in the Wavefront metrics data format:
+// <metricName> <metricValue> [<timestamp>] source=<source> [pointTags]
+func (p *Proxy) handleMetric(line string) error {
+	tokens, err := lineformat.Tokenize(line)
+	if err != nil {
+		return err
+	}
+	if len(tokens) < 2 || tokens[0].HasKey || tokens[1].HasKey {
+		return errors.New("expected metric name and value")
+	}
+
+	m := Metric{Name: tokens[0].Value, Tags: make(map[string]string)}
+	for _, prefix := range deltaPrefixes {
+		if strings.HasPrefix(m.Name, prefix) {
+			m.Name, m.Delta = strings.TrimPrefix(m.Name, prefix), true
+		}
+	}
+	if m.Value, err = strconv.ParseFloat(tokens[1].Value, 64); err != nil {
+		return fmt.Errorf("invalid metric value %q", tokens[1].Value)
+	}
+	rest := tokens[2:]
+	if len(rest) > 0 && !rest[0].HasKey {
+		if m.Timestamp, err = strconv.ParseInt(rest[0].Value, 10, 64); err != nil {
+			return fmt.Errorf("invalid timestamp %q", rest[0].Value)
+		}
+		rest = rest[1:]
+	}
+	if m.Source, err = parseTags(rest, m.Tags); err != nil {
+		return err
+	}
+
+	p.mtx.Lock()
+	p.metrics = append(p.metrics, m)
+	p.mtx.Unlock()
+	return nil
+}
+
+// handleHistogram parses a line in the Wavefront histogram data format:
+// {!M | !H | !D} [<timestamp>] #<count> <mean> [centroids] <histogramName> source=<source> [pointTags]
+func (p *Proxy) handleHistogram(line string) error {
+	tokens, err := lineformat.Tokenize(line)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 || !isGranularity(tokens[0].Value) {
+		return errors.New("expected histogram granularity")
+	}
+
+	h := Histogram{Granularity: tokens[0].Value, Tags: make(map[string]string)}
+	rest := tokens[1:]
+	if len(rest) > 0 && !rest[0].HasKey && !strings.HasPrefix(rest[0].Value, "#") {
+		if h.Timestamp, err = strconv.ParseInt(rest[0].Value, 10, 64); err != nil {
+			return fmt.Errorf("invalid timestamp %q", rest[0].Value)
+		}
+		rest = rest[1:]
+	}
+	for len(rest) >= 2 && strings.HasPrefix(rest[0].Value, "#") {
+		var c Centroid
+		if c.Count, err = strconv.Atoi(rest[0].Value[1:]); err != nil {
+			return fmt.Errorf("invalid centroid count %q", rest[0].Value)
+		}
+		if c.Value, err = strconv.ParseFloat(rest[1].Value, 64); err != nil {
+			return fmt.Errorf("invalid centroid value %q", rest[1].Value)
+		}
+		h.Centroids = append(h.Centroids, c)
+		rest = rest[2:]
+	}
+	if len(h.Centroids) == 0 {
+		return errors.New("expected centroids")
+	}
+	if len(rest) == 0 || rest[0].HasKey {
+		return errors.New("expected histogram name")
+	}
+	h.Name = rest[0].Value
+	if h.Source, err = parseTags(rest[1:], h.Tags); err != nil {
+		return err
+	}
+
+	p.mtx.Lock()
+	p.histograms = append(p.histograms, h)
+	p.mtx.Unlock()
+	return nil
+}
+
+func isGranularity(s string) bool {
+	return s == "!M" || s == "!H" || s == "!D"
+}
+
+// parseTags adds the point tags to tags and returns the source.
+func parseTags(tokens []lineformat.Token, tags map[string]string) (string, error) {
+	var source string
+	for _, tok := range tokens {
+		if !tok.HasKey {
+			return "", fmt.Errorf("expected tag, found %q", tok.Value)
+		}
+		if tok.Key == "source" {
+			source = tok.Value
+		} else {
+			tags[tok.Key] = tok.Value
+		}
+	}
+	return source, nil
+}
+
+// handleTracing returns the handler of span lines and span logs JSON lines, which are attached to
+// the span with the same trace and span ids.
+func (p *Proxy) handleTracing() func(line string) error {
+	return func(line string) error {
+		if strings.HasPrefix(line, "{") {
+			traceID, spanID, logs, err := reporter.ParseSpanLogs(line)
+			if err != nil {
+				return err
+			}
+			p.mtx.Lock()
+			defer p.mtx.Unlock()
+			for i := len(p.spans) - 1; i >= 0; i-- {
+				if p.spans[i].Context.TraceID == traceID && p.spans[i].Context.SpanID == spanID {
+					p.spans[i].Logs = append(p.spans[i].Logs, logs...)
+					return nil
+				}
+			}
+			return fmt.Errorf("span logs for unknown span %s", spanID)
+		}
+
+		span, source, err := reporter.ParseSpanLine(line)
+		if err != nil {
+			return err
+		}
+		p.mtx.Lock()
+		p.spans = append(p.spans, Span{RawSpan: span, Source: source})
+		p.mtx.Unlock()
+		return nil
+	}
+}
+
+// Metrics returns the metrics received so far, including heartbeats.
+func (p *Proxy) Metrics() []Metric {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return append([]Metric(nil), p.metrics...)
+}
+
+// MetricsNamed returns the metrics received so far with the given name, without delta prefix.
+func (p *Proxy) MetricsNamed(name string) []Metric {
+	var metrics []Metric
+	for _, m := range p.Metrics() {
+		if m.Name == name {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// Heartbeats returns the heartbeat metrics received so far.
+func (p *Proxy) Heartbeats() []Metric {
+	return p.MetricsNamed(HeartbeatMetric)
+}
+
+// Histograms returns the histograms received so far.
+func (p *Proxy) Histograms() []Histogram {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return append([]Histogram(nil), p.histograms...)
+}
+
+// HistogramsNamed returns the histograms received so far with the given name.
+func (p *Proxy) HistogramsNamed(name string) []Histogram {
+	var histograms []Histogram
+	for _, h := range p.Histograms() {
+		if h.Name == name {
+			histograms = append(histograms, h)
+		}
+	}
+	return histograms
+}
+
+// Spans returns the spans received so far.
+func (p *Proxy) Spans() []Span {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return append([]Span(nil), p.spans...)
+}
+
+// SpansNamed returns the spans received so far with the given operation name.
+func (p *Proxy) SpansNamed(operation string) []Span {
+	var spans []Span
+	for _, s := range p.Spans() {
+		if s.Operation == operation {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// Errors returns the errors parsing the lines received so far.
+func (p *Proxy) Errors() []error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return append([]error(nil), p.errors...)
+}
+
+// Reset forgets the data received so far.
+func (p *Proxy) Reset() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.metrics, p.histograms, p.spans, p.errors = nil, nil, nil, nil
+}
+
+// Wait polls cond until it returns true or the timeout expires, and returns the last result of cond.
+func (p *Proxy) Wait(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// WaitForSpans waits until at least n spans are received or the timeout expires, and returns the spans received.
+func (p *Proxy) WaitForSpans(n int, timeout time.Duration) []Span {
+	p.Wait(timeout, func() bool { return len(p.Spans()) >= n })
+	return p.Spans()
+}
@@ -0,0 +1,124 @@
+package wavefronttest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/reporter"
+	"github.com/wavefronthq/wavefront-opentracing-sdk-go/tracer"
+	"github.com/wavefronthq/wavefront-sdk-go/application"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+func TestProxyReceivesReporterData(t *testing.T) {
+	proxy, err := NewProxy()
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	sender, err := senders.NewProxySender(proxy.Config())
+	require.NoError(t, err)
+	defer sender.Close()
+
+	r := reporter.New(sender, application.New("app", "svc"), reporter.Source("test-host"))
+	tr := tracer.New(r)
+	span := tr.StartSpan("get-user")
+	span.SetTag("http.method", "GET")
+	span.LogKV("event", "cache miss")
+	span.Finish()
+	require.NoError(t, r.Flush(context.Background()))
+	require.NoError(t, r.Close())
+
+	spans := proxy.WaitForSpans(1, 5*time.Second)
+	require.Len(t, spans, 1)
+	assert.Equal(t, "get-user", spans[0].Operation)
+	assert.Equal(t, "test-host", spans[0].Source)
+	assert.Equal(t, "GET", spans[0].Tags["http.method"])
+	assert.Equal(t, "svc", spans[0].Tags["service"])
+	require.Len(t, spans[0].Logs, 1)
+	assert.Equal(t, "cache miss", spans[0].Logs[0].Fields[0].Value())
+
+	assert.True(t, proxy.Wait(5*time.Second, func() bool {
+		return len(proxy.MetricsNamed("tracing.derived.app.svc.get-user.invocation.count")) > 0 &&
+			len(proxy.Heartbeats()) > 0
+	}), "metrics: %v", proxy.Metrics())
+
+	invocations := proxy.MetricsNamed("tracing.derived.app.svc.get-user.invocation.count")
+	assert.True(t, invocations[0].Delta)
+	assert.Equal(t, 1.0, invocations[0].Value)
+	assert.Equal(t, "get-user", invocations[0].Tags["operationName"])
+	assert.Equal(t, "test-host", proxy.Heartbeats()[0].Source)
+	assert.Empty(t, proxy.Errors())
+}
+
+func TestProxyParsesLines(t *testing.T) {
+	proxy, err := NewProxy()
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	cfg := proxy.Config()
+	send(t, cfg.MetricsPort, "\"cpu.usage\" 42.5 1533529977 source=\"host1\" \"env\"=\"prod\"\n"+
+		"∆requests 3 source=host1\n"+
+		"not-a-metric\n")
+	send(t, cfg.DistributionPort, "!M 1533529977 #20 30.0 #10 5.1 \"latency\" source=\"host1\" \"env\"=\"prod\"\n")
+
+	require.True(t, proxy.Wait(5*time.Second, func() bool {
+		return len(proxy.Metrics()) == 2 && len(proxy.Histograms()) == 1 && len(proxy.Errors()) == 1
+	}))
+
+	assert.Equal(t, []Metric{
+		{Name: "cpu.usage", Value: 42.5, Timestamp: 1533529977, Source: "host1", Tags: map[string]string{"env": "prod"}},
+		{Name: "requests", Delta: true, Value: 3, Source: "host1", Tags: map[string]string{}},
+	}, proxy.Metrics())
+	assert.Equal(t, []Histogram{{
+		Name:        "latency",
+		Granularity: "!M",
+		Timestamp:   1533529977,
+		Centroids:   []Centroid{{Value: 30, Count: 20}, {Value: 5.1, Count: 10}},
+		Source:      "host1",
+		Tags:        map[string]string{"env": "prod"},
+	}}, proxy.Histograms())
+	assert.Contains(t, proxy.Errors()[0].Error(), "not-a-metric")
+
+	proxy.Reset()
+	assert.Empty(t, proxy.Metrics())
+	assert.Empty(t, proxy.Histograms())
+	assert.Empty(t, proxy.Errors())
+}
+
+func TestProxyAttachesSpanLogs(t *testing.T) {
+	proxy, err := NewProxy()
+	require.NoError(t, err)
+	defer proxy.Close()
+
+	traceID, spanID := "7b3bf470-9456-11e8-9eb6-529269fb1459", "0313bafe-9457-11e8-9eb6-529269fb1459"
+	send(t, proxy.Config().TracingPort,
+		fmt.Sprintf("\"getAllUsers\" source=\"localhost\" traceId=%s spanId=%s \"_spanLogs\"=\"true\" 1552949776000 343\n", traceID, spanID)+
+			fmt.Sprintf("{\"traceId\":%q,\"spanId\":%q,\"logs\":[{\"timestamp\":1552949776000000,\"fields\":{\"event\":\"error\"}}]}\n", traceID, spanID))
+
+	spans := proxy.WaitForSpans(1, 5*time.Second)
+	require.True(t, proxy.Wait(5*time.Second, func() bool { return len(proxy.Spans()[0].Logs) == 1 }))
+	spans = proxy.SpansNamed("getAllUsers")
+	require.Len(t, spans, 1)
+	assert.Equal(t, traceID, spans[0].Context.TraceID)
+	assert.Equal(t, "localhost", spans[0].Source)
+	assert.Equal(t, []opentracing.LogRecord{{
+		Timestamp: time.Unix(1552949776, 0),
+		Fields:    spans[0].Logs[0].Fields,
+	}}, spans[0].Logs)
+	assert.Equal(t, "error", spans[0].Logs[0].Fields[0].Value())
+	assert.Empty(t, proxy.Errors())
+}
+
+func send(t *testing.T, port int, data string) {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(data))
+	require.NoError(t, err)
+}